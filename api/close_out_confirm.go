@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"quant_api/backend"
+	"quant_api/confirm"
+	"quant_api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CloseOutConfirmation 第一步返回的确认信息, 单只股票时附带 quant_core 的预览
+type CloseOutConfirmation struct {
+	ConfirmToken string        `json:"confirm_token"`
+	ExpireAt     int64         `json:"expire_at"`
+	Requester    string        `json:"requester"`
+	StockCodes   []string      `json:"stock_codes"`
+	Preview      *CloseOutInfo `json:"preview,omitempty"`
+	PreviewError string        `json:"preview_error,omitempty"`
+} // @name CloseOutConfirmation
+
+// initCloseOutConfirm 未配置密钥时使用随机密钥, 令牌只在本进程内有效, 多实例
+// 部署或重启后会被拒绝, 因此开启确认时由 config.Validate 要求配置密钥
+func (s *Service) initCloseOutConfirm() {
+	secret := []byte(s.cfg.CloseOut.ConfirmationSecret)
+	if len(secret) == 0 {
+		if s.cfg.CloseOut.RequireConfirmation {
+			s.Logger.Warn("close_out.confirmation_secret is not set, confirmation tokens are only valid in this process")
+		}
+
+		var err error
+		if secret, err = confirm.RandomSecret(); err != nil {
+			panic("generate confirmation secret: " + err.Error())
+		}
+	}
+	s.confirmSigner = confirm.NewSigner(secret, s.cfg.ConfirmationTTL())
+}
+
+// confirmCloseOut 校验平仓确认, 返回 false 时已写入响应: 未携带令牌时签发
+// 令牌并要求确认, 令牌无效时返回错误. 未开启确认或携带特权令牌时直接放行.
+// 通过时返回已使用的令牌 nonce, 平仓未能记录时由调用方释放.
+//
+// 本服务没有用户认证, requester 由客户端填写, 令牌与 requester 绑定只防止
+// 误用他人或其他股票的令牌及重复提交, 不能防止伪造 requester; 需要防止
+// 未授权平仓时应在网关认证.
+func (s *Service) confirmCloseOut(c *gin.Context, requester string, stockCodes []string, token string) (string, bool) {
+	if !s.cfg.CloseOut.RequireConfirmation || s.privileged(c) {
+		return "", true
+	}
+
+	subject := closeOutSubject(stockCodes)
+	if token == "" {
+		confirmation := &CloseOutConfirmation{Requester: requester, StockCodes: stockCodes}
+		token, claims, err := s.confirmSigner.Issue(requester, subject)
+		if err != nil {
+			SetHTTPResponse(c, -1, nil, "签发确认令牌失败: "+err.Error())
+			return "", false
+		}
+		confirmation.ConfirmToken = token
+		confirmation.ExpireAt = claims.Expire
+		if len(stockCodes) == 1 {
+			s.previewConfirmation(c.Request.Context(), confirmation)
+		}
+
+		s.Logger.Info("close out confirmation issued", "requester", requester, "stock_codes", stockCodes, "expire_at", claims.Expire)
+		SetHTTPResponse(c, CodeConfirmRequired, confirmation, fmt.Sprintf("请在 %d 秒内携带 confirm_token 确认平仓", int(s.cfg.ConfirmationTTL().Seconds())))
+		return "", false
+	}
+
+	claims, err := s.confirmSigner.Verify(token, requester, subject)
+	switch {
+	case errors.Is(err, confirm.ErrExpiredToken):
+		SetHTTPResponse(c, -1, nil, "确认令牌已过期")
+		return "", false
+	case errors.Is(err, confirm.ErrTokenBinding):
+		SetHTTPResponse(c, -1, nil, "确认令牌与用户或股票不匹配")
+		return "", false
+	case err != nil:
+		SetHTTPResponse(c, -1, nil, "确认令牌无效")
+		return "", false
+	}
+
+	err = models.UseCloseOutConfirmation(claims.Nonce, requester, subject, claims.Expire)
+	if errors.Is(err, models.ErrConfirmationUsed) {
+		SetHTTPResponse(c, -1, nil, "确认令牌已使用")
+		return "", false
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "记录确认令牌失败: "+err.Error())
+		return "", false
+	}
+
+	return claims.Nonce, true
+}
+
+func (s *Service) previewConfirmation(ctx context.Context, confirmation *CloseOutConfirmation) {
+	closeOutResponse, err := s.quantCore.PreviewCloseOut(ctx, confirmation.StockCodes[0])
+	switch {
+	case errors.Is(err, backend.ErrPreviewUnsupported):
+		confirmation.PreviewError = "计算模块不支持平仓预览"
+	case err != nil:
+		confirmation.PreviewError = closeOutErrorMessage(err)
+	default:
+		confirmation.Preview = &closeOutResponse.Data
+	}
+}
+
+// releaseCloseOutConfirmation 平仓未能记录时释放令牌, 用户可以使用同一令牌重试
+func (s *Service) releaseCloseOutConfirmation(nonce string) {
+	if nonce == "" {
+		return
+	}
+	if err := models.ReleaseCloseOutConfirmation(nonce); err != nil {
+		s.Logger.Error("release close out confirmation failed", "error", err)
+	}
+}
+
+// privileged 请求携带了配置的特权令牌
+func (s *Service) privileged(c *gin.Context) bool {
+	token := bearerToken(c)
+	if token == "" {
+		return false
+	}
+
+	for _, privileged := range s.cfg.CloseOut.PrivilegedTokens {
+		if privileged != "" && subtle.ConstantTimeCompare([]byte(token), []byte(privileged)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the token of the Authorization: Bearer header
+func bearerToken(c *gin.Context) string {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+// closeOutKeyUsed 相同 Idempotency-Key 的重放返回首次的结果, 无需再次确认
+func closeOutKeyUsed(key string) bool {
+	if key == "" {
+		return false
+	}
+	_, err := models.GetCloseOutRecordByKey(key)
+	return err == nil
+}
+
+// batchCloseOutKeyUsed 批量平仓的每只股票都已有相同 Idempotency-Key 的记录,
+// 重放返回首次的结果, 无需再次确认
+func batchCloseOutKeyUsed(key string, stockCodes []string) bool {
+	if key == "" {
+		return false
+	}
+	for _, stockCode := range stockCodes {
+		if !closeOutKeyUsed(batchCloseOutKey(key, stockCode)) {
+			return false
+		}
+	}
+	return true
+}
+
+// closeOutSubject 令牌绑定的股票列表, 与顺序无关
+func closeOutSubject(stockCodes []string) string {
+	sorted := append([]string(nil), stockCodes...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
@@ -2,17 +2,18 @@ package api
 
 import (
 	"fmt"
+	"time"
 
 	"quant_api/config"
 )
 
 type Config struct {
-	Host    string `json:"host"`
-	Port    int    `json:"port"`
-	Backend map[string]struct {
-		Host string `json:"host"`
-		Port int    `json:"port"`
-	}
+	Host         string `json:"host"`
+	Port         int    `json:"port"`
+	Backend      map[string]config.Backend
+	ConfigCenter config.ConfigCenter
+	Publisher    config.Publisher
+	CloseOut     config.CloseOut
 }
 
 func (c *Config) GetBackend(name string) string {
@@ -20,6 +21,10 @@ func (c *Config) GetBackend(name string) string {
 		return ""
 	}
 	if v, ok := c.Backend[name]; ok {
+		// 多实例时返回主实例, 故障切换由 backend.Client 处理
+		if len(v.Endpoints) > 0 {
+			return fmt.Sprintf("%s:%d", v.Endpoints[0].Host, v.Endpoints[0].Port)
+		}
 		return fmt.Sprintf("%s:%d", v.Host, v.Port)
 	}
 	return ""
@@ -27,8 +32,53 @@ func (c *Config) GetBackend(name string) string {
 
 func NewConfig(c *config.Config) *Config {
 	return &Config{
-		Host:    c.Http.Host,
-		Port:    c.Http.Port,
-		Backend: c.Backend,
+		Host:         c.Http.Host,
+		Port:         c.Http.Port,
+		Backend:      c.Backend,
+		ConfigCenter: c.ConfigCenter,
+		Publisher:    c.Publisher,
+		CloseOut:     c.CloseOut,
+	}
+}
+
+func (c *Config) TombstoneRetention() time.Duration {
+	if c.ConfigCenter.TombstoneRetentionHours <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return time.Duration(c.ConfigCenter.TombstoneRetentionHours) * time.Hour
+}
+
+func (c *Config) OutboxRetention() time.Duration {
+	if c.ConfigCenter.OutboxRetentionHours <= 0 {
+		return 72 * time.Hour
+	}
+	return time.Duration(c.ConfigCenter.OutboxRetentionHours) * time.Hour
+}
+
+func (c *Config) StaleAfter() time.Duration {
+	if c.ConfigCenter.StaleAfterSeconds <= 0 {
+		return 120 * time.Second
+	}
+	return time.Duration(c.ConfigCenter.StaleAfterSeconds) * time.Second
+}
+
+func (c *Config) MaxConfigLag() time.Duration {
+	if c.ConfigCenter.MaxLagSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(c.ConfigCenter.MaxLagSeconds) * time.Second
+}
+
+func (c *Config) ConfirmationTTL() time.Duration {
+	if c.CloseOut.ConfirmationTTLSeconds <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(c.CloseOut.ConfirmationTTLSeconds) * time.Second
+}
+
+func (c *Config) CloseOutJobMaxAge() time.Duration {
+	if c.CloseOut.JobMaxAgeSeconds <= 0 {
+		return 60 * time.Second
 	}
+	return time.Duration(c.CloseOut.JobMaxAgeSeconds) * time.Second
 }
@@ -1,12 +1,12 @@
 package api
 
 import (
-	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
 	"quant_api/models"
 
@@ -15,22 +15,54 @@ import (
 
 func (s *Service) InitHandlers() {
 	s.GET("/hello", s.hello)
+	s.GET("/healthz", s.Healthz)
+	s.GET("/readyz", s.Readyz)
 
 	// add close_out handler
 	s.POST("/stock/close_out", s.closeOut)
+	s.GET("/stock/close_out", s.GetCloseOutRecords)
+	s.POST("/stock/close_out/batch", s.BatchCloseOut)
+	s.POST("/stock/close_out/jobs", s.CreateCloseOutJob)
+	s.GET("/stock/close_out/jobs/:id", s.GetCloseOutJob)
+	s.GET("/stock/close_out/jobs/:id/stream", s.StreamCloseOutJob)
 
 	// add config update handler
 	s.GET("/stock/configs", s.GetStockConfigs)
+	s.GET("/stock/configs/:code", s.GetStockConfig)
 	s.POST("/stock/configs", s.AddStockConfig)
 	s.PUT("/stock/configs", s.UpdateStockConfig)
 	s.DELETE("/stock/configs", s.DeleteStockConfig)
+	s.GET("/stock/configs/:code/history", s.GetStockConfigHistory)
+	s.POST("/stock/configs/:code/rollback", s.RollbackStockConfig)
 
 	s.GET("/global/configs", s.GetGlobalConfigs)
+	s.GET("/global/configs/:name", s.GetGlobalConfig)
 	s.POST("/global/configs", s.AddGlobalConfig)
 	s.PUT("/global/configs", s.UpdateGlobalConfig)
+	s.GET("/global/configs/:name/history", s.GetGlobalConfigHistory)
+	s.POST("/global/configs/:name/rollback", s.RollbackGlobalConfig)
 
 	s.GET("/config_center/configs", s.ConfigCenterGetConfigs)
 	s.GET("/config_center/status", s.ConfigCenterGetStatus)
+	s.GET("/config_center/stream", s.ConfigCenterStream)
+	s.GET("/config_center/snapshot", s.ConfigCenterGetSnapshot)
+	s.POST("/config_center/ack", s.ConfigCenterAck)
+	s.POST("/config_center/register", s.ConfigCenterRegister)
+	s.POST("/config_center/heartbeat", s.ConfigCenterHeartbeat)
+	s.GET("/config_center/drift", s.ConfigCenterGetDrift)
+
+	s.GET("/webhooks", s.GetWebhooks)
+	s.POST("/webhooks", s.CreateWebhook)
+	s.DELETE("/webhooks/:id", s.DeleteWebhook)
+	s.GET("/webhooks/:id/deliveries", s.GetWebhookDeliveries)
+
+	s.GET("/config_center/rollouts", s.GetRollouts)
+	s.POST("/config_center/rollouts", s.CreateRollout)
+	s.POST("/config_center/rollouts/:id/promote", s.PromoteRollout)
+	s.POST("/config_center/rollouts/:id/abort", s.AbortRollout)
+
+	s.GET("/backends", s.GetBackends)
+	s.Any("/backend/:name/*path", s.ProxyBackend)
 }
 
 func (s *Service) hello(c *gin.Context) {
@@ -40,9 +72,14 @@ func (s *Service) hello(c *gin.Context) {
 	return
 }
 
-type CloseOut struct {
-	StockCode string `json:"stock_code"  binding:"required"`
-}
+// 业务错误码
+const (
+	CodeOK              = 0
+	CodeError           = -1
+	CodeVersionConflict = -2
+	CodePartialSuccess  = -3
+	CodeConfirmRequired = -4
+)
 
 func SetHTTPResponse(c *gin.Context, code int, data interface{}, message string) {
 	if data == nil {
@@ -55,98 +92,6 @@ func SetHTTPResponse(c *gin.Context, code int, data interface{}, message string)
 	})
 }
 
-// 反序列化结果
-type CloseOutResponse struct {
-	Data    CloseOutInfo `json:"data"`
-	Message string       `json:"message"`
-}
-
-type CloseOutInfo struct {
-	LastPrice          float64     `json:"price"`
-	CanceledEnterTasks []int       `json:"canceled_enter_tasks"`
-	CanceledExitTasks  []int       `json:"canceled_exit_tasks"`
-	CloseOutQty        map[int]int `json:"close_out_qty"`
-	TotalQty           int         `json:"total_qty"`
-}
-
-func (s *Service) closeOut(c *gin.Context) {
-	var closeOut CloseOut
-	if err := c.ShouldBindJSON(&closeOut); err != nil {
-		SetHTTPResponse(c, -1, nil, "参数错误")
-		return
-	}
-
-	s.Logger.Info("closeOut", "closeOut", closeOut)
-
-	if closeOut.StockCode == "" {
-		SetHTTPResponse(c, -1, nil, "stock_code 不能为空")
-		return
-	}
-
-	// do something
-	quantCoreBackend := s.cfg.GetBackend("quant_core")
-
-	// build http request to quant_core_backend
-	// use http request post method
-	// with json body
-
-	// 设置请求地址和方法
-	url := fmt.Sprintf("http://%s/stock/close_out", quantCoreBackend)
-	method := "POST"
-
-	// 设置请求体
-	jsonData, err := json.Marshal(closeOut)
-	if err != nil {
-		SetHTTPResponse(c, -1, nil, "序列化请求体失败")
-		return
-	}
-
-	// 创建 HTTP 请求
-	req, err := http.NewRequest(method, url, bytes.NewReader(jsonData))
-	if err != nil {
-		SetHTTPResponse(c, -1, nil, "创建请求失败")
-		return
-	}
-
-	// 设置请求头部信息
-	req.Header.Set("Content-Type", "application/json")
-
-	// 创建 HTTP 客户端并发送请求
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		msg := fmt.Sprintf("请求失败: %s", err.Error())
-		SetHTTPResponse(c, -1, nil, msg)
-		return
-	}
-	defer resp.Body.Close()
-
-	// 解析响应
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		SetHTTPResponse(c, -1, nil, "解析响应失败:"+err.Error())
-		return
-	}
-
-	var closeOutResponse CloseOutResponse
-
-	if err := json.Unmarshal(body, &closeOutResponse); err != nil {
-		SetHTTPResponse(c, -1, nil, "反序列化失败:"+err.Error())
-		return
-	}
-
-	if resp.StatusCode != 200 {
-		SetHTTPResponse(c, -1, nil, "计算模块处理异常， "+closeOutResponse.Message)
-		return
-
-	}
-
-	SetHTTPResponse(c, 0, closeOutResponse.Data, closeOutResponse.Message)
-	return
-}
-
 // GetStockConfigs
 func (s *Service) GetStockConfigs(c *gin.Context) {
 	scope := "stock"
@@ -162,6 +107,11 @@ func (s *Service) GetStockConfigs(c *gin.Context) {
 	SetHTTPResponse(c, 0, data, "查询成功")
 }
 
+// GetStockConfig
+func (s *Service) GetStockConfig(c *gin.Context) {
+	s.getConfig(c, "stock", c.Param("code"))
+}
+
 type StockConfig struct {
 	StockCode string `json:"stock_code"  binding:"required"`
 	Config    struct {
@@ -170,6 +120,7 @@ type StockConfig struct {
 		UpLimit    *float64 `json:"up_limit,omitempty"`
 		LowLimit   *float64 `json:"low_limit,omitempty"`
 	} `json:"config"`
+	Version    *int   `json:"version,omitempty"`
 	UpdateUser string `json:"update_user"  binding:"required"`
 }
 
@@ -250,6 +201,10 @@ func (s *Service) UpdateStockConfig(c *gin.Context) {
 		return
 	}
 
+	if !s.checkConfigVersion(c, oldConfig, stockConfig.Version) {
+		return
+	}
+
 	if err := oldConfig.MergeValue(valueObject); err != nil {
 		SetHTTPResponse(c, -1, nil, "合并配置失败: "+err.Error())
 		return
@@ -258,10 +213,15 @@ func (s *Service) UpdateStockConfig(c *gin.Context) {
 	oldConfig.UpdateUser = stockConfig.UpdateUser
 
 	if err := oldConfig.Save(); err != nil {
+		if errors.Is(err, models.ErrVersionConflict) {
+			SetHTTPResponse(c, CodeVersionConflict, nil, "配置已被他人修改，请刷新后重试")
+			return
+		}
 		SetHTTPResponse(c, -1, nil, "保存配置失败: "+err.Error())
 		return
 	}
 
+	setConfigETag(c, oldConfig)
 	data := make(map[string]interface{})
 	data["config"] = oldConfig
 	SetHTTPResponse(c, 0, data, "更新成功")
@@ -289,6 +249,8 @@ func (s *Service) DeleteStockConfig(c *gin.Context) {
 		return
 	}
 
+	oldConfig.UpdateUser = stockConfig.UpdateUser
+
 	if err := oldConfig.Delete(); err != nil {
 		SetHTTPResponse(c, -1, nil, "删除失败: "+err.Error())
 		return
@@ -312,11 +274,17 @@ func (s *Service) GetGlobalConfigs(c *gin.Context) {
 	SetHTTPResponse(c, 0, data, "查询成功")
 }
 
+// GetGlobalConfig
+func (s *Service) GetGlobalConfig(c *gin.Context) {
+	s.getConfig(c, "global", c.Param("name"))
+}
+
 type GlobalConfig struct {
 	Name   string `json:"name" binding:"required"`
 	Config struct {
 		Broker string `json:"broker,omitempty" binding:"required"`
 	} `json:"config"`
+	Version    *int   `json:"version,omitempty"`
 	UpdateUser string `json:"update_user"  binding:"required"`
 }
 
@@ -397,6 +365,10 @@ func (s *Service) UpdateGlobalConfig(c *gin.Context) {
 		return
 	}
 
+	if !s.checkConfigVersion(c, oldConfig, globalConfig.Version) {
+		return
+	}
+
 	if err := oldConfig.MergeValue(valueObject); err != nil {
 		SetHTTPResponse(c, -1, nil, "合并配置失败: "+err.Error())
 		return
@@ -405,11 +377,118 @@ func (s *Service) UpdateGlobalConfig(c *gin.Context) {
 	oldConfig.UpdateUser = globalConfig.UpdateUser
 
 	if err := oldConfig.Save(); err != nil {
+		if errors.Is(err, models.ErrVersionConflict) {
+			SetHTTPResponse(c, CodeVersionConflict, nil, "配置已被他人修改，请刷新后重试")
+			return
+		}
 		SetHTTPResponse(c, -1, nil, "保存配置失败: "+err.Error())
 		return
 	}
 
+	setConfigETag(c, oldConfig)
 	data := make(map[string]interface{})
 	data["config"] = oldConfig
 	SetHTTPResponse(c, 0, data, "更新成功")
 }
+
+// GetStockConfigHistory
+func (s *Service) GetStockConfigHistory(c *gin.Context) {
+	s.getConfigHistory(c, "stock", c.Param("code"))
+}
+
+// GetGlobalConfigHistory
+func (s *Service) GetGlobalConfigHistory(c *gin.Context) {
+	s.getConfigHistory(c, "global", c.Param("name"))
+}
+
+func (s *Service) getConfigHistory(c *gin.Context, scope, name string) {
+	revisions, err := models.GetConfigRevisions(scope, name)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取历史版本失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["revisions"] = revisions
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
+
+type RollbackParams struct {
+	RevisionID int    `json:"revision_id" binding:"required"`
+	UpdateUser string `json:"update_user" binding:"required"`
+}
+
+// RollbackStockConfig
+func (s *Service) RollbackStockConfig(c *gin.Context) {
+	s.rollbackConfig(c, "stock", c.Param("code"))
+}
+
+// RollbackGlobalConfig
+func (s *Service) RollbackGlobalConfig(c *gin.Context) {
+	s.rollbackConfig(c, "global", c.Param("name"))
+}
+
+func (s *Service) rollbackConfig(c *gin.Context, scope, name string) {
+	var params RollbackParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	s.Logger.Info("RollbackConfig", "scope", scope, "name", name, "params", params)
+
+	config, err := models.RollbackConfig(scope, name, params.RevisionID, params.UpdateUser)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "回滚失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["config"] = config
+	SetHTTPResponse(c, 0, data, "回滚成功")
+}
+
+func (s *Service) getConfig(c *gin.Context, scope, name string) {
+	config, err := models.GetConfig(scope, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		SetHTTPResponse(c, -1, nil, "配置不存在")
+		return
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取配置失败: "+err.Error())
+		return
+	}
+
+	setConfigETag(c, config)
+	data := make(map[string]interface{})
+	data["config"] = config
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
+
+// setConfigETag 以配置版本号作为 ETag 返回
+func setConfigETag(c *gin.Context, config *models.Config) {
+	c.Header("ETag", strconv.Quote(strconv.Itoa(config.Version)))
+}
+
+// checkConfigVersion 校验 If-Match 头或请求体中的 version 与当前版本是否一致,
+// 都未提供时不做校验
+func (s *Service) checkConfigVersion(c *gin.Context, config *models.Config, version *int) bool {
+	expected := version
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" && ifMatch != "*" {
+		v, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+		if err != nil {
+			SetHTTPResponse(c, -1, nil, "If-Match 格式错误")
+			return false
+		}
+		expected = &v
+	}
+
+	if expected != nil && *expected != config.Version {
+		setConfigETag(c, config)
+		msg := fmt.Sprintf("配置已被他人修改，当前版本 %d，请求版本 %d", config.Version, *expected)
+		SetHTTPResponse(c, CodeVersionConflict, nil, msg)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"quant_api/backend"
+	"quant_api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 代理请求体的大小上限
+const maxProxyBodySize = 10 << 20
+
+// GetBackends godoc
+func (s *Service) GetBackends(c *gin.Context) {
+	backends := make([]*backend.Status, 0, len(s.backends))
+	for _, client := range s.backends {
+		backends = append(backends, client.Status())
+	}
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Name < backends[j].Name
+	})
+
+	data := make(map[string]interface{})
+	data["backends"] = backends
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
+
+// ProxyBackend godoc
+// 把 /backend/:name/*path 转发到配置的后端, 需携带后端 proxy_tokens 中的
+// 令牌, 只转发后端 proxy 白名单内的方法与路径, 请求头与响应头经过过滤, 并
+// 透传 X-Request-Id. 代理请求使用单独的熔断器, 不影响平仓等调用.
+func (s *Service) ProxyBackend(c *gin.Context) {
+	start := time.Now()
+	name := c.Param("name")
+	method := c.Request.Method
+	path := backend.CleanProxyPath(c.Param("path"))
+
+	requestID := c.GetHeader(backend.HeaderRequestID)
+	if requestID == "" {
+		requestID = utils.NewUUIDV4()
+	}
+	c.Header(backend.HeaderRequestID, requestID)
+
+	logger := s.Logger.With("backend", name, "method", method, "path", path, "request_id", requestID, "client_ip", c.ClientIP())
+
+	client, ok := s.backends[name]
+	if !ok {
+		logger.Warn("proxy rejected", "reason", "unknown backend")
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "data": gin.H{}, "message": "后端不存在"})
+		return
+	}
+	if !client.ProxyAuthorized(bearerToken(c)) {
+		logger.Warn("proxy rejected", "reason", "unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"code": -1, "data": gin.H{}, "message": "未授权的代理请求"})
+		return
+	}
+	if !client.ProxyAllowed(method, path) {
+		logger.Warn("proxy rejected", "reason", "not allowed")
+		c.JSON(http.StatusForbidden, gin.H{"code": -1, "data": gin.H{}, "message": "不允许代理该请求"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxProxyBodySize))
+	if err != nil {
+		logger.Warn("proxy rejected", "reason", "read body failed", "error", err)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"code": -1, "data": gin.H{}, "message": "读取请求体失败"})
+		return
+	}
+
+	header := backend.ProxyRequestHeader(c.Request.Header)
+	header.Set("X-Forwarded-For", c.ClientIP())
+
+	ctx := backend.WithRequestID(c.Request.Context(), requestID)
+	resp, err := client.Do(ctx, &backend.Request{
+		Method:     method,
+		Path:       path,
+		RawQuery:   c.Request.URL.RawQuery,
+		Header:     header,
+		RawBody:    body,
+		Idempotent: method == http.MethodGet || method == http.MethodHead,
+		Proxy:      true,
+	})
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, backend.ErrCircuitOpen) {
+			status = http.StatusServiceUnavailable
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		logger.Error("proxy failed", "status", status, "duration", time.Since(start), "error", err)
+		c.JSON(status, gin.H{"code": -1, "data": gin.H{}, "message": "请求后端失败: " + err.Error()})
+		return
+	}
+
+	logger.Info("proxy", "status", resp.StatusCode, "duration", time.Since(start), "bytes", len(resp.Body))
+
+	backend.CopyProxyResponseHeader(c.Writer.Header(), resp.Header)
+	c.Header(backend.HeaderRequestID, requestID)
+	c.Status(resp.StatusCode)
+	c.Writer.Write(resp.Body)
+}
@@ -0,0 +1,554 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"quant_api/backend"
+	"quant_api/models"
+	"quant_api/utils"
+	"quant_api/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderIdempotencyKey 相同 key 的平仓请求只执行一次, 重复请求返回首次的结果
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// Idempotency-Key 的长度上限. 批量平仓每只股票的 key 以 batchKeyPrefix 开头,
+// 客户端的 key 不能使用该前缀, 避免单只平仓重放批量平仓的结果
+const (
+	maxIdempotencyKeyLen = 64
+	batchKeyPrefix       = "batch:"
+)
+
+// close_out_records.request_id 的长度, 客户端传入的 X-Request-Id 超过
+// maxClientRequestIDLen 时改用服务端生成的 id
+const (
+	maxRequestIDLen       = 50
+	maxClientRequestIDLen = 36
+)
+
+// CloseOut DryRun 为 true 时只预览将撤销的任务与平仓数量, 不执行也不记录.
+// 开启两步确认时 ConfirmToken 为第一步返回的确认令牌.
+type CloseOut struct {
+	StockCode    string `json:"stock_code"  binding:"required"`
+	Requester    string `json:"requester"`
+	DryRun       bool   `json:"dry_run"`
+	ConfirmToken string `json:"confirm_token"`
+}
+
+// CloseOutResponse 与 CloseOutInfo 定义在 backend 包中
+type (
+	CloseOutResponse = backend.CloseOutResponse
+	CloseOutInfo     = backend.CloseOutInfo
+)
+
+// CloseOutResult 单只股票的平仓结果, Replayed 表示结果来自相同
+// Idempotency-Key 的首次请求
+type CloseOutResult struct {
+	StockCode string        `json:"stock_code"`
+	RequestID string        `json:"request_id"`
+	Success   bool          `json:"success"`
+	Data      *CloseOutInfo `json:"data,omitempty"`
+	Message   string        `json:"message"`
+	Error     string        `json:"error,omitempty"`
+	Replayed  bool          `json:"replayed,omitempty"`
+	DryRun    bool          `json:"dry_run,omitempty"`
+
+	// 平仓已记录在 close_out_records, 未记录时可释放确认令牌
+	recorded bool
+} // @name CloseOutResult
+
+func (s *Service) closeOut(c *gin.Context) {
+	var closeOut CloseOut
+	if err := c.ShouldBindJSON(&closeOut); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	s.Logger.Info("closeOut", "stock_code", closeOut.StockCode, "requester", closeOut.Requester, "dry_run", closeOut.DryRun)
+
+	if closeOut.StockCode == "" {
+		SetHTTPResponse(c, -1, nil, "stock_code 不能为空")
+		return
+	}
+	if closeOut.Requester == "" {
+		closeOut.Requester = "admin"
+	}
+
+	key, ok := idempotencyKey(c)
+	if !ok {
+		return
+	}
+	var nonce string
+	if !closeOut.DryRun && !closeOutKeyUsed(key) {
+		if nonce, ok = s.confirmCloseOut(c, closeOut.Requester, []string{closeOut.StockCode}, closeOut.ConfirmToken); !ok {
+			return
+		}
+	}
+
+	requestID := closeOutRequestID(c)
+
+	// 请求已发往 quant_core 后不随客户端断开而取消, 保证结果被记录
+	ctx := context.WithoutCancel(c.Request.Context())
+	result := s.runCloseOut(ctx, closeOut, requestID, key)
+	if !result.recorded {
+		s.releaseCloseOutConfirmation(nonce)
+	}
+	c.Header(backend.HeaderRequestID, result.RequestID)
+	if !result.Success {
+		SetHTTPResponse(c, -1, nil, result.Error)
+		return
+	}
+
+	SetHTTPResponse(c, 0, result.Data, result.Message)
+	return
+}
+
+// runCloseOut 记录并执行一次平仓, key 非空时相同 key 只执行一次
+func (s *Service) runCloseOut(ctx context.Context, closeOut CloseOut, requestID, key string) *CloseOutResult {
+	if closeOut.Requester == "" {
+		closeOut.Requester = "admin"
+	}
+
+	result := &CloseOutResult{StockCode: closeOut.StockCode, RequestID: requestID}
+	if closeOut.DryRun {
+		return s.previewCloseOut(ctx, closeOut, result)
+	}
+
+	record := &models.CloseOutRecord{
+		RequestID: requestID,
+		StockCode: closeOut.StockCode,
+		Requester: closeOut.Requester,
+	}
+	if key != "" {
+		record.IdempotencyKey = &key
+	}
+
+	err := record.Create()
+	if errors.Is(err, models.ErrDuplicateIdempotency) {
+		return s.replayCloseOut(key, closeOut, requestID)
+	}
+	if err != nil {
+		result.Error = "保存平仓记录失败: " + err.Error()
+		return result
+	}
+
+	result = s.executeCloseOut(ctx, record)
+	result.recorded = true
+	return result
+}
+
+// executeCloseOut 认领 pending 的记录并请求 quant_core 平仓, 记录已超过
+// CloseOutJobMaxAge 时不再执行
+func (s *Service) executeCloseOut(ctx context.Context, record *models.CloseOutRecord) *CloseOutResult {
+	claimed, err := record.Claim(s.cfg.CloseOutJobMaxAge())
+	if errors.Is(err, models.ErrCloseOutExpired) {
+		return closeOutResult(record)
+	}
+	if err != nil {
+		return &CloseOutResult{StockCode: record.StockCode, RequestID: record.RequestID, Error: "更新平仓记录失败: " + err.Error()}
+	}
+	if !claimed {
+		return &CloseOutResult{StockCode: record.StockCode, RequestID: record.RequestID, Error: "平仓请求正在处理中"}
+	}
+
+	closeOutResponse, err := s.quantCore.CloseOut(backend.WithRequestID(ctx, record.RequestID), record.StockCode)
+	s.saveCloseOut(record, closeOutResponse, err)
+	s.notifyCloseOut(record)
+
+	return closeOutResult(record)
+}
+
+// previewCloseOut 请求 quant_core 预览平仓. 本服务只保存配置, 没有任务与
+// 持仓数据, quant_core 不支持预览时无法在本地计算, 直接返回错误.
+func (s *Service) previewCloseOut(ctx context.Context, closeOut CloseOut, result *CloseOutResult) *CloseOutResult {
+	result.DryRun = true
+
+	closeOutResponse, err := s.quantCore.PreviewCloseOut(backend.WithRequestID(ctx, result.RequestID), closeOut.StockCode)
+	if errors.Is(err, backend.ErrPreviewUnsupported) {
+		result.Error = "计算模块不支持平仓预览"
+		return result
+	}
+	if err != nil {
+		result.Error = closeOutErrorMessage(err)
+		return result
+	}
+
+	result.Success = true
+	result.Data = &closeOutResponse.Data
+	result.Message = "预览: " + closeOutResponse.Message
+	return result
+}
+
+// replayCloseOut 返回 Idempotency-Key 首次请求的结果
+func (s *Service) replayCloseOut(key string, closeOut CloseOut, requestID string) *CloseOutResult {
+	result := &CloseOutResult{StockCode: closeOut.StockCode, RequestID: requestID}
+
+	record, err := models.GetCloseOutRecordByKey(key)
+	if err != nil {
+		result.Error = "查询平仓记录失败: " + err.Error()
+		return result
+	}
+
+	s.Logger.Info("replay closeOut", "idempotency_key", key, "request_id", record.RequestID, "status", record.Status)
+
+	if record.StockCode != closeOut.StockCode {
+		result.Error = fmt.Sprintf("Idempotency-Key 已用于 %s 的平仓请求", record.StockCode)
+		return result
+	}
+
+	result = closeOutResult(record)
+	result.Replayed = true
+	return result
+}
+
+// 批量平仓默认与最大并发数
+const (
+	batchCloseOutConcurrency    = 8
+	maxBatchCloseOutConcurrency = 32
+)
+
+// BatchCloseOut All 为 true 时平仓所有 prod_status 为 true 的股票, 与
+// StockCodes 合并去重. 确认令牌绑定最终的股票列表, 列表变化时需重新确认.
+type BatchCloseOut struct {
+	StockCodes   []string `json:"stock_codes"`
+	All          bool     `json:"all"`
+	Requester    string   `json:"requester"`
+	Concurrency  int      `json:"concurrency"`
+	DryRun       bool     `json:"dry_run"`
+	ConfirmToken string   `json:"confirm_token"`
+}
+
+// BatchCloseOutResult 按请求顺序返回每只股票的结果
+type BatchCloseOutResult struct {
+	Results   []*CloseOutResult `json:"results"`
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	TotalQty  int               `json:"total_qty"`
+} // @name BatchCloseOutResult
+
+// BatchCloseOut godoc
+func (s *Service) BatchCloseOut(c *gin.Context) {
+	var param BatchCloseOut
+	if err := c.ShouldBindJSON(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	s.Logger.Info("BatchCloseOut", "stock_codes", param.StockCodes, "all", param.All, "requester", param.Requester)
+
+	key, ok := idempotencyKey(c)
+	if !ok {
+		return
+	}
+
+	stockCodes, err := s.batchCloseOutStocks(param)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取持仓股票失败: "+err.Error())
+		return
+	}
+	if len(stockCodes) == 0 {
+		SetHTTPResponse(c, -1, nil, "没有需要平仓的股票")
+		return
+	}
+	if param.Requester == "" {
+		param.Requester = "admin"
+	}
+	var nonce string
+	if !param.DryRun && !batchCloseOutKeyUsed(key, stockCodes) {
+		if nonce, ok = s.confirmCloseOut(c, param.Requester, stockCodes, param.ConfirmToken); !ok {
+			return
+		}
+	}
+
+	concurrency := param.Concurrency
+	if concurrency <= 0 {
+		concurrency = batchCloseOutConcurrency
+	} else if concurrency > maxBatchCloseOutConcurrency {
+		concurrency = maxBatchCloseOutConcurrency
+	}
+
+	batchID := closeOutRequestID(c)
+	c.Header(backend.HeaderRequestID, batchID)
+
+	ctx := context.WithoutCancel(c.Request.Context())
+	result := &BatchCloseOutResult{Results: make([]*CloseOutResult, len(stockCodes))}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, stockCode := range stockCodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, stockCode string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// 每只股票单独记录, 重放时按股票返回首次的结果
+			stockKey := batchCloseOutKey(key, stockCode)
+			requestID := batchID + ":" + stockCode
+			if len(requestID) > maxRequestIDLen {
+				requestID = utils.NewUUIDV4()
+			}
+			closeOut := CloseOut{StockCode: stockCode, Requester: param.Requester, DryRun: param.DryRun}
+			result.Results[i] = s.runCloseOut(ctx, closeOut, requestID, stockKey)
+		}(i, stockCode)
+	}
+	wg.Wait()
+
+	// 任一股票已记录时令牌已生效, 全部未记录时才释放
+	recorded := false
+	result.Total = len(stockCodes)
+	for _, r := range result.Results {
+		recorded = recorded || r.recorded
+		if r.Success {
+			result.Succeeded++
+			result.TotalQty += r.Data.TotalQty
+		} else {
+			result.Failed++
+		}
+	}
+
+	if !recorded {
+		s.releaseCloseOutConfirmation(nonce)
+	}
+
+	s.Logger.Info("BatchCloseOut finished", "request_id", batchID, "dry_run", param.DryRun, "total", result.Total, "succeeded", result.Succeeded, "failed", result.Failed)
+
+	action := "平仓"
+	if param.DryRun {
+		action = "预览平仓"
+	}
+	switch {
+	case result.Failed == 0:
+		SetHTTPResponse(c, 0, result, fmt.Sprintf("%s成功 %d 只", action, result.Succeeded))
+	case result.Succeeded == 0:
+		SetHTTPResponse(c, -1, result, fmt.Sprintf("%s全部失败 %d 只", action, result.Failed))
+	default:
+		SetHTTPResponse(c, CodePartialSuccess, result, fmt.Sprintf("部分%s失败: 成功 %d 只, 失败 %d 只", action, result.Succeeded, result.Failed))
+	}
+}
+
+// idempotencyKey 读取 Idempotency-Key, 返回 false 时已写入响应
+func idempotencyKey(c *gin.Context) (string, bool) {
+	key := c.GetHeader(HeaderIdempotencyKey)
+	if len(key) > maxIdempotencyKeyLen {
+		SetHTTPResponse(c, -1, nil, fmt.Sprintf("Idempotency-Key 不能超过 %d 个字符", maxIdempotencyKeyLen))
+		return "", false
+	}
+	if strings.HasPrefix(key, batchKeyPrefix) {
+		SetHTTPResponse(c, -1, nil, fmt.Sprintf("Idempotency-Key 不能以 %s 开头", batchKeyPrefix))
+		return "", false
+	}
+	return key, true
+}
+
+// batchCloseOutKey 批量平仓中一只股票的 key, key 为空时不做幂等. 批量的 key
+// 取摘要, 保证不超过 close_out_records.idempotency_key 的长度
+func batchCloseOutKey(key, stockCode string) string {
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return batchKeyPrefix + hex.EncodeToString(sum[:16]) + ":" + stockCode
+}
+
+// closeOutRequestID 使用客户端传入的 X-Request-Id, 未传入或过长时生成新的 id
+func closeOutRequestID(c *gin.Context) string {
+	requestID := c.GetHeader(backend.HeaderRequestID)
+	if requestID == "" || len(requestID) > maxClientRequestIDLen {
+		return utils.NewUUIDV4()
+	}
+	return requestID
+}
+
+// batchCloseOutStocks 合并请求中的股票与 prod_status 为 true 的股票
+func (s *Service) batchCloseOutStocks(param BatchCloseOut) ([]string, error) {
+	stockCodes := make([]string, 0, len(param.StockCodes))
+	seen := make(map[string]bool)
+	add := func(stockCode string) {
+		if stockCode != "" && !seen[stockCode] {
+			seen[stockCode] = true
+			stockCodes = append(stockCodes, stockCode)
+		}
+	}
+
+	for _, stockCode := range param.StockCodes {
+		add(stockCode)
+	}
+
+	if param.All {
+		configs, err := models.GetConfigs("stock")
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range configs {
+			if prodStatus, _ := config.Value["prod_status"].(bool); prodStatus {
+				add(config.Name)
+			}
+		}
+	}
+
+	return stockCodes, nil
+}
+
+// closeOutErrorMessage 把调用 quant_core 的错误转换为展示给用户的信息
+func closeOutErrorMessage(err error) string {
+	var statusErr *backend.StatusError
+	var decodeErr *backend.DecodeError
+	switch {
+	case errors.As(err, &statusErr):
+		return "计算模块处理异常， " + statusErr.Message
+	case errors.As(err, &decodeErr):
+		return "反序列化失败:" + decodeErr.Err.Error()
+	case errors.Is(err, backend.ErrCircuitOpen):
+		return "计算模块暂时不可用, 请稍后重试"
+	case errors.Is(err, backend.ErrNotConfigured):
+		return "未配置计算模块地址"
+	default:
+		return fmt.Sprintf("请求失败: %s", err.Error())
+	}
+}
+
+// saveCloseOut 记录平仓结果, 失败只记日志, 不影响返回给用户的结果. 请求
+// 可能已被执行但没有收到结果时记为 unknown, 等待核对.
+func (s *Service) saveCloseOut(record *models.CloseOutRecord, closeOutResponse *CloseOutResponse, closeOutErr error) {
+	// 记录处理请求的实例, 核对时向该实例查询
+	record.Endpoint = backend.ErrorAddr(closeOutErr)
+	if closeOutResponse != nil {
+		setCloseOutInfo(record, closeOutResponse.Data)
+		record.Message = closeOutResponse.Message
+		record.Endpoint = closeOutResponse.Addr
+	}
+	switch {
+	case closeOutErr == nil:
+		record.Status = models.CloseOutStatusSucceeded
+	case backend.Uncertain(closeOutErr):
+		record.Status = models.CloseOutStatusUnknown
+	default:
+		record.Status = models.CloseOutStatusFailed
+	}
+	if closeOutErr != nil {
+		record.Error = closeOutErrorMessage(closeOutErr)
+	}
+
+	if err := record.Save(); err != nil {
+		s.Logger.Error("save close out record failed", "id", record.ID, "request_id", record.RequestID, "error", err)
+	}
+}
+
+func setCloseOutInfo(record *models.CloseOutRecord, info CloseOutInfo) {
+	record.Price = info.LastPrice
+	record.CanceledEnterTasks = info.CanceledEnterTasks
+	record.CanceledExitTasks = info.CanceledExitTasks
+	record.CloseOutQty = info.CloseOutQty
+	record.TotalQty = info.TotalQty
+}
+
+func closeOutInfo(record *models.CloseOutRecord) CloseOutInfo {
+	return CloseOutInfo{
+		LastPrice:          record.Price,
+		CanceledEnterTasks: record.CanceledEnterTasks,
+		CanceledExitTasks:  record.CanceledExitTasks,
+		CloseOutQty:        record.CloseOutQty,
+		TotalQty:           record.TotalQty,
+	}
+}
+
+// closeOutResult 按记录的状态返回结果
+func closeOutResult(record *models.CloseOutRecord) *CloseOutResult {
+	result := &CloseOutResult{StockCode: record.StockCode, RequestID: record.RequestID, Message: record.Message, recorded: true}
+	switch record.Status {
+	case models.CloseOutStatusSucceeded:
+		info := closeOutInfo(record)
+		result.Success = true
+		result.Data = &info
+	case models.CloseOutStatusFailed:
+		result.Error = record.Error
+	case models.CloseOutStatusUnknown:
+		result.Error = "平仓结果未知, 正在与计算模块核对: " + record.Error
+	default:
+		result.Error = "平仓请求正在处理中"
+	}
+	return result
+}
+
+// notifyCloseOut 把平仓结果投递给订阅的 webhook
+func (s *Service) notifyCloseOut(record *models.CloseOutRecord) {
+	data := map[string]interface{}{
+		"stock_code": record.StockCode,
+		"request_id": record.RequestID,
+		"status":     record.Status,
+		"success":    record.Status == models.CloseOutStatusSucceeded,
+		"message":    record.Message,
+	}
+	if record.Status == models.CloseOutStatusSucceeded {
+		data["result"] = closeOutInfo(record)
+	}
+	if record.Error != "" {
+		data["error"] = record.Error
+	}
+
+	event := webhook.NewEvent(webhook.EventCloseOut, "stock", record.StockCode, data)
+	if err := s.webhooks.Notify(event); err != nil {
+		s.Logger.Error("notify close out webhook failed", "stock_code", record.StockCode, "error", err)
+	}
+}
+
+// CloseOutHistoryParams 日期为 2006-01-02 格式, 包含结束日期当天
+type CloseOutHistoryParams struct {
+	StockCode string `form:"stock_code"`
+	StartDate string `form:"start_date"`
+	EndDate   string `form:"end_date"`
+	Limit     int    `form:"limit"`
+}
+
+// GetCloseOutRecords godoc
+func (s *Service) GetCloseOutRecords(c *gin.Context) {
+	var param CloseOutHistoryParams
+	if err := c.ShouldBindQuery(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	filter := models.CloseOutFilter{StockCode: param.StockCode, Limit: param.Limit}
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	} else if filter.Limit > 1000 {
+		filter.Limit = 1000
+	}
+
+	if param.StartDate != "" {
+		start, err := time.ParseInLocation(time.DateOnly, param.StartDate, time.Local)
+		if err != nil {
+			SetHTTPResponse(c, -1, nil, "start_date 格式错误")
+			return
+		}
+		filter.Since = start.Format(time.DateTime)
+	}
+	if param.EndDate != "" {
+		end, err := time.ParseInLocation(time.DateOnly, param.EndDate, time.Local)
+		if err != nil {
+			SetHTTPResponse(c, -1, nil, "end_date 格式错误")
+			return
+		}
+		filter.Until = end.AddDate(0, 0, 1).Format(time.DateTime)
+	}
+
+	records, err := models.GetCloseOutRecords(filter)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "查询平仓记录失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["records"] = records
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
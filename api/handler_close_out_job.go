@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"quant_api/backend"
+	"quant_api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 异步平仓任务的推送间隔
+const closeOutJobPollInterval = time.Second
+
+// CreateCloseOutJob godoc
+func (s *Service) CreateCloseOutJob(c *gin.Context) {
+	var closeOut CloseOut
+	if err := c.ShouldBindJSON(&closeOut); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	s.Logger.Info("CreateCloseOutJob", "stock_code", closeOut.StockCode, "requester", closeOut.Requester)
+
+	if closeOut.StockCode == "" {
+		SetHTTPResponse(c, -1, nil, "stock_code 不能为空")
+		return
+	}
+	if closeOut.DryRun {
+		SetHTTPResponse(c, -1, nil, "预览不支持异步任务")
+		return
+	}
+	if closeOut.Requester == "" {
+		closeOut.Requester = "admin"
+	}
+
+	key, ok := idempotencyKey(c)
+	if !ok {
+		return
+	}
+	var nonce string
+	if !closeOutKeyUsed(key) {
+		if nonce, ok = s.confirmCloseOut(c, closeOut.Requester, []string{closeOut.StockCode}, closeOut.ConfirmToken); !ok {
+			return
+		}
+	}
+
+	requestID := closeOutRequestID(c)
+
+	record := &models.CloseOutRecord{
+		RequestID: requestID,
+		StockCode: closeOut.StockCode,
+		Requester: closeOut.Requester,
+		Async:     true,
+	}
+	if key != "" {
+		record.IdempotencyKey = &key
+	}
+
+	err := record.Create()
+	if errors.Is(err, models.ErrDuplicateIdempotency) {
+		existing, err := models.GetCloseOutRecordByKey(key)
+		if err != nil {
+			SetHTTPResponse(c, -1, nil, "查询平仓记录失败: "+err.Error())
+			return
+		}
+		if existing.StockCode != closeOut.StockCode {
+			SetHTTPResponse(c, -1, nil, fmt.Sprintf("Idempotency-Key 已用于 %s 的平仓请求", existing.StockCode))
+			return
+		}
+
+		c.Header(backend.HeaderRequestID, existing.RequestID)
+		data := make(map[string]interface{})
+		data["job"] = existing
+		SetHTTPResponse(c, 0, data, "任务已存在")
+		return
+	}
+	if err != nil {
+		s.releaseCloseOutConfirmation(nonce)
+		SetHTTPResponse(c, -1, nil, "保存平仓记录失败: "+err.Error())
+		return
+	}
+
+	// 队列已满时由定时扫描补充
+	select {
+	case s.closeOutQueue <- record.ID:
+	default:
+	}
+
+	c.Header(backend.HeaderRequestID, requestID)
+	data := make(map[string]interface{})
+	data["job"] = record
+	SetHTTPResponse(c, 0, data, "提交成功")
+}
+
+// GetCloseOutJob godoc
+func (s *Service) GetCloseOutJob(c *gin.Context) {
+	record, ok := s.loadCloseOutJob(c)
+	if !ok {
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["job"] = record
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
+
+// StreamCloseOutJob godoc
+// 以 SSE 推送任务状态, 每次状态变化推送一条 status 事件, 任务成功或失败后
+// 结束. 核对次数用尽仍为 unknown 时再推送一次状态后结束, 需要人工确认.
+func (s *Service) StreamCloseOutJob(c *gin.Context) {
+	record, ok := s.loadCloseOutJob(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	ctx := c.Request.Context()
+	ticker := time.NewTicker(closeOutJobPollInterval)
+	defer ticker.Stop()
+
+	var sent string
+	lastWrite := time.Now()
+	for {
+		exhausted := closeOutReconcileExhausted(record)
+		if record.Status != sent || exhausted {
+			if err := writeJobSSE(c.Writer, record); err != nil {
+				return
+			}
+			sent = record.Status
+			lastWrite = time.Now()
+		} else if time.Since(lastWrite) >= streamKeepAlive {
+			if _, err := io.WriteString(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			lastWrite = time.Now()
+		}
+		c.Writer.Flush()
+
+		if closeOutFinished(record.Status) || exhausted {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		next, err := models.GetCloseOutRecord(record.ID)
+		if err != nil {
+			s.Logger.Error("load close out job failed", "id", record.ID, "error", err)
+			return
+		}
+		record = next
+	}
+}
+
+func (s *Service) loadCloseOutJob(c *gin.Context) (*models.CloseOutRecord, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "id 格式错误")
+		return nil, false
+	}
+
+	record, err := models.GetCloseOutRecord(id)
+	if errors.Is(err, models.ErrCloseOutRecordNotFound) {
+		SetHTTPResponse(c, -1, nil, "任务不存在")
+		return nil, false
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "查询任务失败: "+err.Error())
+		return nil, false
+	}
+
+	return record, true
+}
+
+func writeJobSSE(w io.Writer, record *models.CloseOutRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+	return err
+}
+
+func closeOutFinished(status string) bool {
+	return status == models.CloseOutStatusSucceeded || status == models.CloseOutStatusFailed
+}
+
+// closeOutReconcileExhausted 记录仍为 unknown, 但不会再向 quant_core 核对
+func closeOutReconcileExhausted(record *models.CloseOutRecord) bool {
+	return record.Status == models.CloseOutStatusUnknown && record.ReconcileAttempts >= closeOutReconcileAttempts
+}
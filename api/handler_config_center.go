@@ -1,6 +1,15 @@
 package api
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
 	"quant_api/models"
@@ -9,8 +18,111 @@ import (
 )
 
 type SyncParams struct {
-	ClientID   string `json:"client_id"`
-	UpdateTime int64  `json:"update_time"`
+	ClientID string `form:"client_id" json:"client_id" binding:"required"`
+	// Cursor 为客户端已处理到的变更序号, 不传时使用客户端确认(ack)过的位置
+	Cursor *int64 `form:"cursor" json:"cursor"`
+	// Wait 长轮询等待时长, 如 30s, 没有新变更时阻塞直到有变更或超时
+	Wait string `form:"wait" json:"wait"`
+}
+
+// 长轮询最长等待时间
+const maxSyncWait = 60 * time.Second
+
+// parseWait 解析等待时长, 支持 "30s" 与纯数字秒数
+func parseWait(wait string) (time.Duration, error) {
+	if wait == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(wait)
+	if err != nil {
+		seconds, err := strconv.Atoi(wait)
+		if err != nil {
+			return 0, err
+		}
+		d = time.Duration(seconds) * time.Second
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("negative wait %s", wait)
+	}
+	if d > maxSyncWait {
+		d = maxSyncWait
+	}
+
+	return d, nil
+}
+
+// clientConfigs 返回客户端视角下 (cursor, upto] 内的配置变更: 灰度状态变化
+// 的配置重新下发给灰度客户端, 处于灰度中的配置带上灰度值, 最后按订阅过滤.
+// client 为空时返回全部变更.
+func clientConfigs(client *models.ConfigClient, cursor, upto int64) ([]*models.Config, error) {
+	configs, err := models.GetConfigsAfterSeq(cursor, upto)
+	if err != nil || client == nil {
+		return configs, err
+	}
+
+	rollouts, err := models.GetRolloutsAfterSeq(cursor, upto)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		changed[config.Scope+"/"+config.Name] = true
+	}
+	for _, r := range rollouts {
+		// 全量发布时配置本身已变更
+		if r.Status == models.RolloutStatusPromoted || !r.Targets(client.ClientID) || changed[r.Scope+"/"+r.Name] {
+			continue
+		}
+
+		config, err := models.GetConfig(r.Scope, r.Name)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		changed[r.Scope+"/"+r.Name] = true
+		configs = append(configs, config)
+	}
+
+	active, err := models.GetRollouts(models.RolloutStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	configs = models.ApplyRollouts(client.ClientID, configs, active)
+
+	return client.Subscriptions.Filter(configs), nil
+}
+
+// pollConfigs 返回 cursor 之后客户端视角的配置变更和新的游标, 没有变更时按
+// wait 长轮询. 游标推进到读取时已提交的最新序号, 未订阅的变更不会被重复扫描.
+func pollConfigs(ctx context.Context, client *models.ConfigClient, cursor int64, wait time.Duration) ([]*models.Config, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	for {
+		// 序号按提交顺序分配, upto 及之前的变更都已提交
+		upto, err := models.CurrentSeq()
+		if err != nil {
+			return nil, cursor, err
+		}
+
+		configs := make([]*models.Config, 0)
+		if upto > cursor {
+			configs, err = clientConfigs(client, cursor, upto)
+			if err != nil {
+				return nil, cursor, err
+			}
+			cursor = upto
+		}
+
+		if len(configs) > 0 || wait <= 0 || !models.WaitForChange(ctx, cursor) {
+			return configs, cursor, nil
+		}
+	}
 }
 
 // ConfigCenterGetConfigs godoc
@@ -22,26 +134,48 @@ func (s *Service) ConfigCenterGetConfigs(c *gin.Context) {
 		return
 	}
 
+	wait, err := parseWait(param.Wait)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "wait 参数错误")
+		return
+	}
+
 	syncStatus, err := models.LoadSyncStatus(param.ClientID)
+	if errors.Is(err, models.ErrClientNotRegistered) {
+		SetHTTPResponse(c, -1, nil, "客户端未注册")
+		return
+	}
 	if err != nil {
 		SetHTTPResponse(c, -1, nil, "获取同步状态失败: "+err.Error())
 		return
 	}
 
-	if syncStatus == nil {
-		SetHTTPResponse(c, -1, nil, "未找到同步状态")
+	client, err := models.GetConfigClient(param.ClientID)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取客户端失败: "+err.Error())
 		return
 	}
 
-	var updateTime int64
+	cursor := syncStatus.AppliedCursor
+	if param.Cursor != nil {
+		cursor = *param.Cursor
+	}
 
-	if param.UpdateTime != 0 {
-		updateTime = param.UpdateTime
-	} else if syncStatus != nil {
-		updateTime = syncStatus.UpdateTime
+	purged, err := cursorPurged(cursor)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取变更序号失败: "+err.Error())
+		return
+	}
+	if purged {
+		data := make(map[string]interface{})
+		data["configs"] = []*models.Config{}
+		data["cursor"] = cursor
+		data["resync"] = true
+		SetHTTPResponse(c, 0, data, "游标之前的删除记录已清理, 请重新拉取快照")
+		return
 	}
 
-	configs, err := models.GetConfigsAfterTime(updateTime)
+	configs, cursor, err := pollConfigs(c.Request.Context(), client, cursor, wait)
 	if err != nil {
 		SetHTTPResponse(c, -1, nil, "获取配置失败: "+err.Error())
 		return
@@ -50,26 +184,611 @@ func (s *Service) ConfigCenterGetConfigs(c *gin.Context) {
 	// 返回所有配置
 	data := make(map[string]interface{})
 	data["configs"] = configs
+	data["cursor"] = cursor
+	data["resync"] = false
 
 	SetHTTPResponse(c, 0, data, "查询成功")
 
 	syncStatus.UpdateTime = time.Now().Unix()
+	syncStatus.DeliveredCursor = cursor
 	err = syncStatus.Save()
 	if err != nil {
 		s.Logger.Error("save sync status failed", "error", err)
 	}
 }
 
+type SnapshotParams struct {
+	// ClientID 不为空时只返回该客户端订阅的配置
+	ClientID string `form:"client_id" json:"client_id"`
+}
+
+// loadClient 加载客户端, clientID 为空时返回 nil, 即不区分客户端
+func (s *Service) loadClient(c *gin.Context, clientID string) (*models.ConfigClient, bool) {
+	if clientID == "" {
+		return nil, true
+	}
+
+	client, err := models.GetConfigClient(clientID)
+	if errors.Is(err, models.ErrClientNotRegistered) {
+		SetHTTPResponse(c, -1, nil, "客户端未注册")
+		return nil, false
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取客户端失败: "+err.Error())
+		return nil, false
+	}
+
+	return client, true
+}
+
+// ConfigCenterGetSnapshot godoc
+// 返回全部有效配置及其对应的变更序号和校验值, 新客户端以此启动后从 cursor
+// 开始增量同步
+func (s *Service) ConfigCenterGetSnapshot(c *gin.Context) {
+	var param SnapshotParams
+	if err := c.ShouldBindQuery(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	client, ok := s.loadClient(c, param.ClientID)
+	if !ok {
+		return
+	}
+
+	configs, seq, err := models.GetConfigSnapshot(c.Request.Context())
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取配置快照失败: "+err.Error())
+		return
+	}
+
+	if client != nil {
+		active, err := models.GetRollouts(models.RolloutStatusActive)
+		if err != nil {
+			SetHTTPResponse(c, -1, nil, "获取灰度发布失败: "+err.Error())
+			return
+		}
+		configs = models.ApplyRollouts(client.ClientID, configs, active)
+		configs = client.Subscriptions.Filter(configs)
+	}
+
+	data := make(map[string]interface{})
+	data["configs"] = configs
+	data["cursor"] = seq
+	data["hash"] = models.HashConfigs(configs)
+	data["count"] = len(configs)
+
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
+
+type AckParams struct {
+	ClientID string `json:"client_id" binding:"required"`
+	// Cursor 客户端实际已应用到的变更序号
+	Cursor *int64 `json:"cursor" binding:"required"`
+	// Hash 与 ScopeHashes 为已应用配置的校验值, 计算方式同 models.HashConfigs
+	Hash        string                 `json:"hash"`
+	ScopeHashes map[string]interface{} `json:"scope_hashes"`
+}
+
+// ConfigCenterAck godoc
+// 客户端应用配置后确认, 只有确认后才推进 applied 位置
+func (s *Service) ConfigCenterAck(c *gin.Context) {
+	var param AckParams
+	if err := c.ShouldBindJSON(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	seq, err := models.CurrentSeq()
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取变更序号失败: "+err.Error())
+		return
+	}
+
+	if *param.Cursor < 0 || *param.Cursor > seq {
+		SetHTTPResponse(c, -1, nil, fmt.Sprintf("cursor 超出范围, 当前最新序号 %d", seq))
+		return
+	}
+
+	syncStatus, err := models.LoadSyncStatus(param.ClientID)
+	if errors.Is(err, models.ErrClientNotRegistered) {
+		SetHTTPResponse(c, -1, nil, "客户端未注册")
+		return
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取同步状态失败: "+err.Error())
+		return
+	}
+
+	if err := syncStatus.Ack(*param.Cursor, param.Hash, param.ScopeHashes); err != nil {
+		SetHTTPResponse(c, -1, nil, "确认失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["sync_status"] = syncStatus
+	SetHTTPResponse(c, 0, data, "确认成功")
+}
+
+type RegisterParams struct {
+	ClientID    string `json:"client_id" binding:"required"`
+	Name        string `json:"name"`
+	Host        string `json:"host"`
+	Version     string `json:"version"`
+	Environment string `json:"environment"`
+	// Scopes 订阅整个 scope, 与 Subscriptions 合并, 都为空时订阅全部配置
+	Scopes        []string             `json:"scopes"`
+	Subscriptions models.Subscriptions `json:"subscriptions"`
+}
+
+// ConfigCenterRegister godoc
+// 客户端启动时注册, 重复注册会更新客户端信息
+func (s *Service) ConfigCenterRegister(c *gin.Context) {
+	var param RegisterParams
+	if err := c.ShouldBindJSON(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	s.Logger.Info("ConfigCenterRegister", "params", param)
+
+	subscriptions := param.Subscriptions
+	for _, scope := range param.Scopes {
+		subscriptions = append(subscriptions, models.Subscription{Scope: scope})
+	}
+	for _, sub := range subscriptions {
+		if sub.Scope == "" {
+			SetHTTPResponse(c, -1, nil, "订阅的 scope 不能为空")
+			return
+		}
+	}
+
+	client := &models.ConfigClient{
+		ClientID:      param.ClientID,
+		Name:          param.Name,
+		Host:          param.Host,
+		Version:       param.Version,
+		Environment:   param.Environment,
+		Subscriptions: subscriptions,
+	}
+	if err := client.Register(); err != nil {
+		SetHTTPResponse(c, -1, nil, "注册失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["client"] = client
+	SetHTTPResponse(c, 0, data, "注册成功")
+}
+
+type HeartbeatParams struct {
+	ClientID string `json:"client_id" binding:"required"`
+}
+
+// ConfigCenterHeartbeat godoc
+func (s *Service) ConfigCenterHeartbeat(c *gin.Context) {
+	var param HeartbeatParams
+	if err := c.ShouldBindJSON(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	err := models.Heartbeat(param.ClientID)
+	if errors.Is(err, models.ErrClientNotRegistered) {
+		SetHTTPResponse(c, -1, nil, "客户端未注册")
+		return
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "心跳失败: "+err.Error())
+		return
+	}
+
+	SetHTTPResponse(c, 0, nil, "心跳成功")
+}
+
+// ClientStatus 客户端状态, LastActive 为最近一次心跳或同步的时间
+type ClientStatus struct {
+	*models.ConfigClient
+	SyncStatus *models.SyncStatus `json:"sync_status"`
+	LastActive int64              `json:"last_active"`
+	Lag        int64              `json:"lag"`
+	Stale      bool               `json:"stale"`
+}
+
+type StatusParams struct {
+	// StaleAfter 失联判定时长(秒), 不传时使用配置
+	StaleAfter int `form:"stale_after" json:"stale_after"`
+}
+
 // ConfigCenterGetStatus godoc
 func (s *Service) ConfigCenterGetStatus(c *gin.Context) {
+	var param StatusParams
+	if err := c.ShouldBindQuery(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	staleAfter := s.cfg.StaleAfter()
+	if param.StaleAfter > 0 {
+		staleAfter = time.Duration(param.StaleAfter) * time.Second
+	}
+
+	clients, err := models.GetConfigClients()
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取客户端失败: "+err.Error())
+		return
+	}
+
 	syncStatus, err := models.LoadAllSyncStatus()
 	if err != nil {
 		SetHTTPResponse(c, -1, nil, "获取同步状态失败: "+err.Error())
 		return
 	}
 
+	seq, err := models.CurrentSeq()
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取变更序号失败: "+err.Error())
+		return
+	}
+
+	syncStatusMap := make(map[string]*models.SyncStatus)
+	for _, status := range syncStatus {
+		syncStatusMap[status.ClientID] = status
+	}
+
+	now := time.Now().Unix()
+	statuses := make([]*ClientStatus, 0, len(clients))
+	staleCount := 0
+	for _, client := range clients {
+		status := &ClientStatus{
+			ConfigClient: client,
+			SyncStatus:   syncStatusMap[client.ClientID],
+			LastActive:   client.LastSeen,
+		}
+		if status.SyncStatus != nil {
+			status.LastActive = max(status.LastActive, status.SyncStatus.UpdateTime)
+			status.Lag = seq - status.SyncStatus.AppliedCursor
+		}
+		status.Stale = now-status.LastActive > int64(staleAfter.Seconds())
+		if status.Stale {
+			staleCount++
+		}
+		statuses = append(statuses, status)
+	}
+
 	data := make(map[string]interface{})
-	data["sync_status"] = syncStatus
+	data["clients"] = statuses
+	data["seq"] = seq
+	data["stale_after"] = int64(staleAfter.Seconds())
+	data["stale_count"] = staleCount
+
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
+
+// 客户端配置一致性状态
+const (
+	DriftStatusInSync  = "in_sync"
+	DriftStatusDrifted = "drifted"
+	DriftStatusLagging = "lagging"
+	DriftStatusUnknown = "unknown"
+)
+
+// ClientDrift 对比客户端上报的已应用配置校验值与按当前配置计算的期望值.
+// 不一致且客户端未确认到最新序号时为 lagging, 已确认到最新序号仍不一致时
+// 为 drifted, 未上报过校验值时为 unknown.
+type ClientDrift struct {
+	ClientID       string            `json:"client_id"`
+	Name           string            `json:"name"`
+	Host           string            `json:"host"`
+	AppliedCursor  int64             `json:"applied_cursor"`
+	Lag            int64             `json:"lag"`
+	ExpectedHash   string            `json:"expected_hash"`
+	AppliedHash    string            `json:"applied_hash"`
+	ExpectedScopes map[string]string `json:"expected_scopes"`
+	DriftedScopes  []string          `json:"drifted_scopes"`
+	Status         string            `json:"status"`
+}
+
+// ConfigCenterGetDrift godoc
+func (s *Service) ConfigCenterGetDrift(c *gin.Context) {
+	clients, err := models.GetConfigClients()
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取客户端失败: "+err.Error())
+		return
+	}
+
+	syncStatus, err := models.LoadAllSyncStatus()
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取同步状态失败: "+err.Error())
+		return
+	}
+
+	configs, seq, err := models.GetConfigSnapshot(c.Request.Context())
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取配置快照失败: "+err.Error())
+		return
+	}
+
+	active, err := models.GetRollouts(models.RolloutStatusActive)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取灰度发布失败: "+err.Error())
+		return
+	}
+
+	syncStatusMap := make(map[string]*models.SyncStatus)
+	for _, status := range syncStatus {
+		syncStatusMap[status.ClientID] = status
+	}
+
+	drifts := make([]*ClientDrift, 0, len(clients))
+	driftedCount := 0
+	for _, client := range clients {
+		expected := client.Subscriptions.Filter(models.ApplyRollouts(client.ClientID, configs, active))
+		drift := &ClientDrift{
+			ClientID:       client.ClientID,
+			Name:           client.Name,
+			Host:           client.Host,
+			ExpectedHash:   models.HashConfigs(expected),
+			ExpectedScopes: models.HashConfigsByScope(expected),
+			DriftedScopes:  make([]string, 0),
+			Status:         DriftStatusUnknown,
+		}
+
+		if status := syncStatusMap[client.ClientID]; status != nil && status.AppliedHash != "" {
+			drift.AppliedCursor = status.AppliedCursor
+			drift.Lag = seq - status.AppliedCursor
+			drift.AppliedHash = status.AppliedHash
+			drift.DriftedScopes = driftedScopes(drift.ExpectedScopes, status.ScopeHashes)
+
+			switch {
+			case drift.AppliedHash == drift.ExpectedHash:
+				drift.Status = DriftStatusInSync
+			case drift.Lag > 0:
+				drift.Status = DriftStatusLagging
+			default:
+				drift.Status = DriftStatusDrifted
+				driftedCount++
+			}
+		}
+
+		drifts = append(drifts, drift)
+	}
+
+	data := make(map[string]interface{})
+	data["clients"] = drifts
+	data["seq"] = seq
+	data["drifted_count"] = driftedCount
 
 	SetHTTPResponse(c, 0, data, "查询成功")
 }
+
+// driftedScopes 返回上报值与期望值不一致的 scope, 客户端未按 scope 上报时为空
+func driftedScopes(expected map[string]string, applied models.JsonObject) []string {
+	scopes := make([]string, 0)
+	if len(applied) == 0 {
+		return scopes
+	}
+
+	for scope, hash := range expected {
+		if applied[scope] != hash {
+			scopes = append(scopes, scope)
+		}
+	}
+	for scope := range applied {
+		if _, ok := expected[scope]; !ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// SSE 心跳间隔与单次回放条数
+const (
+	streamKeepAlive = 15 * time.Second
+	streamBatchSize = 500
+)
+
+type StreamParams struct {
+	// ClientID 不为空时只推送该客户端订阅的配置
+	ClientID string `form:"client_id" json:"client_id"`
+	// LastEventID 同 Last-Event-ID 头, 供无法设置请求头的客户端使用
+	LastEventID *int64 `form:"last_event_id" json:"last_event_id"`
+}
+
+// ConfigCenterStream godoc
+// 以 Server-Sent Events 推送配置变更, 事件 id 为变更序号, 断线后通过
+// Last-Event-ID 从下一条变更继续. 断点之后的删除已被清理时推送 resync 事件
+// 后结束, 客户端需重新拉取快照.
+func (s *Service) ConfigCenterStream(c *gin.Context) {
+	var param StreamParams
+	if err := c.ShouldBindQuery(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	client, ok := s.loadClient(c, param.ClientID)
+	if !ok {
+		return
+	}
+
+	var cursor int64
+	var resume bool
+	if lastEventID := c.GetHeader("Last-Event-ID"); lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			SetHTTPResponse(c, -1, nil, "Last-Event-ID 格式错误")
+			return
+		}
+		cursor = id
+		resume = true
+	} else if param.LastEventID != nil {
+		cursor = *param.LastEventID
+		resume = true
+	} else {
+		// 没有断点时只推送之后的变更
+		seq, err := models.CurrentSeq()
+		if err != nil {
+			SetHTTPResponse(c, -1, nil, "获取变更序号失败: "+err.Error())
+			return
+		}
+		cursor = seq
+	}
+
+	purged := false
+	if resume {
+		var err error
+		if purged, err = cursorPurged(cursor); err != nil {
+			SetHTTPResponse(c, -1, nil, "获取变更序号失败: "+err.Error())
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// 断点之前的删除已清理, 通知客户端重新拉取快照后结束
+	if purged {
+		io.WriteString(c.Writer, "event: resync\ndata: {}\n\n")
+		c.Writer.Flush()
+		return
+	}
+	c.Writer.Flush()
+
+	ctx := c.Request.Context()
+	for {
+		upto, err := models.CurrentSeq()
+		if err != nil {
+			s.Logger.Error("read config sequence failed", "error", err)
+			return
+		}
+
+		if upto > cursor {
+			events, next, err := clientEvents(client, cursor, upto)
+			if err != nil {
+				s.Logger.Error("read config changelog failed", "error", err)
+				return
+			}
+
+			for _, event := range events {
+				if err := writeSSE(c.Writer, event); err != nil {
+					return
+				}
+			}
+			c.Writer.Flush()
+
+			cursor = next
+			if next < upto {
+				continue
+			}
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, streamKeepAlive)
+		changed := models.WaitForChange(waitCtx, cursor)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+		if !changed {
+			if _, err := io.WriteString(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// clientEvents 返回客户端视角下 (cursor, upto] 内的变更事件和新的游标, 变更
+// 日志单次最多读取 streamBatchSize 条, 读满时游标停在最后一条. 灰度开始和
+// 终止时向灰度客户端推送 rollout_active / rollout_aborted 事件.
+func clientEvents(client *models.ConfigClient, cursor, upto int64) ([]*models.ConfigEvent, int64, error) {
+	revisions, err := models.GetConfigRevisionsAfterSeq(cursor, upto, streamBatchSize)
+	if err != nil {
+		return nil, cursor, err
+	}
+	if len(revisions) == streamBatchSize {
+		upto = revisions[len(revisions)-1].Seq
+	}
+
+	events := make([]*models.ConfigEvent, 0, len(revisions))
+	for _, revision := range revisions {
+		if client == nil || client.Subscriptions.Match(revision.Scope, revision.Name) {
+			events = append(events, revision.Event())
+		}
+	}
+	if client == nil {
+		return events, upto, nil
+	}
+
+	rollouts, err := models.GetRolloutsAfterSeq(cursor, upto)
+	if err != nil {
+		return nil, cursor, err
+	}
+	for _, r := range rollouts {
+		if r.Status == models.RolloutStatusPromoted || !r.Targets(client.ClientID) || !client.Subscriptions.Match(r.Scope, r.Name) {
+			continue
+		}
+
+		config, err := models.GetConfig(r.Scope, r.Name)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, cursor, err
+		}
+		events = append(events, &models.ConfigEvent{
+			Seq:        r.Seq,
+			Action:     "rollout_" + r.Status,
+			Scope:      r.Scope,
+			Name:       r.Name,
+			Value:      config.Value,
+			Version:    config.Version,
+			UpdateUser: r.UpdateUser,
+			Time:       r.UpdateTime,
+		})
+	}
+
+	active, err := models.GetRollouts(models.RolloutStatusActive)
+	if err != nil {
+		return nil, cursor, err
+	}
+	for _, r := range active {
+		if !r.Targets(client.ClientID) {
+			continue
+		}
+		for i, event := range events {
+			if event.Scope == r.Scope && event.Name == r.Name && event.Action != models.RevisionActionDelete {
+				events[i] = r.ApplyEvent(event)
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Seq < events[j].Seq
+	})
+
+	return events, upto, nil
+}
+
+// cursorPurged 游标之后有已清理的墓碑, 增量同步无法得知这些删除
+func cursorPurged(cursor int64) (bool, error) {
+	purgedSeq, err := models.PurgedSeq()
+	if err != nil {
+		return false, err
+	}
+	return cursor < purgedSeq, nil
+}
+
+func writeSSE(w io.Writer, event *models.ConfigEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Action, data)
+	return err
+}
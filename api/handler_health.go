@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"quant_api/backend"
+	"quant_api/database"
+	"quant_api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 组件状态
+const (
+	HealthUp       = "up"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// 单个组件检查的超时时间
+const healthCheckTimeout = 2 * time.Second
+
+// ComponentHealth 一个依赖组件的检查结果, Critical 的组件不可用时服务未就绪
+type ComponentHealth struct {
+	Name      string      `json:"name"`
+	Status    string      `json:"status"`
+	Critical  bool        `json:"critical"`
+	LatencyMs float64     `json:"latency_ms"`
+	Error     string      `json:"error,omitempty"`
+	Detail    interface{} `json:"detail,omitempty"`
+} // @name ComponentHealth
+
+// Health 服务整体状态与各组件明细
+type Health struct {
+	Status     string             `json:"status"`
+	Uptime     int64              `json:"uptime"`
+	Time       int64              `json:"time"`
+	Components []*ComponentHealth `json:"components,omitempty"`
+} // @name Health
+
+// ConfigCenterLag 配置变更事件的投递积压
+type ConfigCenterLag struct {
+	CurrentSeq int64 `json:"current_seq"`
+	Pending    int64 `json:"pending"`
+	OldestSeq  int64 `json:"oldest_seq"`
+	LagSeconds int64 `json:"lag_seconds"`
+	MaxSeconds int64 `json:"max_seconds"`
+} // @name ConfigCenterLag
+
+// Healthz godoc
+// 存活检查, 只说明进程能处理请求, 不检查依赖: 数据库或后端不可用时重启
+// 本服务没有帮助.
+func (s *Service) Healthz(c *gin.Context) {
+	health := &Health{
+		Status: HealthUp,
+		Uptime: int64(time.Since(s.started).Seconds()),
+		Time:   time.Now().Unix(),
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "data": health, "message": "服务正常"})
+}
+
+// Readyz godoc
+// 就绪检查, 并发检查数据库、各后端与配置中心积压. 数据库不可用时返回 503;
+// 后端由所有实例共用, 后端不可用或配置变更积压只报告降级, 仍返回 200, 避免
+// 负载均衡摘除全部实例.
+func (s *Service) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+
+	checks := []func(context.Context) *ComponentHealth{s.checkDatabase, s.checkConfigCenter}
+	names := make([]string, 0, len(s.backends))
+	for name := range s.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		client := s.backends[name]
+		checks = append(checks, func(context.Context) *ComponentHealth {
+			return checkBackend(client)
+		})
+	}
+
+	health := &Health{
+		Status:     HealthUp,
+		Uptime:     int64(time.Since(s.started).Seconds()),
+		Time:       time.Now().Unix(),
+		Components: make([]*ComponentHealth, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func(context.Context) *ComponentHealth) {
+			defer wg.Done()
+			health.Components[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	var down []string
+	for _, component := range health.Components {
+		switch {
+		case component.Status == HealthUp:
+		case component.Critical && component.Status == HealthDown:
+			health.Status = HealthDown
+			down = append(down, component.Name+": "+component.Error)
+		case health.Status == HealthUp:
+			health.Status = HealthDegraded
+		}
+	}
+
+	switch health.Status {
+	case HealthDown:
+		s.Logger.Warn("service not ready", "components", down)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"code": -1, "data": health, "message": "服务不可用"})
+	case HealthDegraded:
+		c.JSON(http.StatusOK, gin.H{"code": 0, "data": health, "message": "服务降级"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"code": 0, "data": health, "message": "服务正常"})
+	}
+}
+
+// checkDatabase pings the database
+func (s *Service) checkDatabase(ctx context.Context) *ComponentHealth {
+	component := &ComponentHealth{Name: "database", Critical: true}
+	start := time.Now()
+
+	err := runCheck(ctx, func() error {
+		db, err := database.GetGlobalDB()
+		if err != nil {
+			return err
+		}
+		return db.PingContext(ctx)
+	})
+	setComponentResult(component, start, err)
+	return component
+}
+
+// checkConfigCenter reports how long the oldest config change has been waiting
+// for the outbox dispatcher
+func (s *Service) checkConfigCenter(ctx context.Context) *ComponentHealth {
+	component := &ComponentHealth{Name: "config_center"}
+	start := time.Now()
+
+	lag := &ConfigCenterLag{MaxSeconds: int64(s.cfg.MaxConfigLag().Seconds())}
+	err := runCheck(ctx, func() error {
+		seq, err := models.CurrentSeq()
+		if err != nil {
+			return err
+		}
+		outboxLag, err := models.GetOutboxLag()
+		if err != nil {
+			return err
+		}
+
+		lag.CurrentSeq = seq
+		lag.Pending = outboxLag.Pending
+		lag.OldestSeq = outboxLag.OldestSeq
+		if outboxLag.Oldest > 0 {
+			lag.LagSeconds = max(time.Now().Unix()-outboxLag.Oldest, 0)
+		}
+		return nil
+	})
+	setComponentResult(component, start, err)
+	if err == nil {
+		component.Detail = lag
+		if lag.LagSeconds > lag.MaxSeconds {
+			component.Status = HealthDegraded
+			component.Error = fmt.Sprintf("配置变更积压 %d 秒", lag.LagSeconds)
+		}
+	}
+	return component
+}
+
+// checkBackend uses the result of the periodic endpoint health checks, so a
+// probe does not add load to the backend
+func checkBackend(client *backend.Client) *ComponentHealth {
+	status := client.Status()
+	component := &ComponentHealth{
+		Name:   "backend:" + status.Name,
+		Status: HealthUp,
+		Detail: status,
+	}
+
+	for _, e := range status.Endpoints {
+		if e.Addr == status.Current {
+			component.LatencyMs = e.LatencyMs
+			component.Error = e.LastError
+		}
+	}
+
+	switch {
+	case len(status.Endpoints) == 0:
+		component.Status = HealthDown
+		component.Error = backend.ErrNotConfigured.Error()
+	case !status.Healthy:
+		component.Status = HealthDown
+	default:
+		for _, e := range status.Endpoints {
+			if !e.Healthy || e.Breaker.State == backend.BreakerOpen {
+				component.Status = HealthDegraded
+			}
+		}
+	}
+
+	return component
+}
+
+// runCheck runs check in its own goroutine and gives up when ctx is done.
+// GetGlobalDB may connect on first use without a timeout, and panics when the
+// global config is not loaded.
+func runCheck(ctx context.Context, check func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- check()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func setComponentResult(component *ComponentHealth, start time.Time, err error) {
+	component.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	component.Status = HealthUp
+	if err != nil {
+		component.Status = HealthDown
+		component.Error = err.Error()
+	}
+}
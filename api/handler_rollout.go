@@ -0,0 +1,196 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"quant_api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RolloutParams struct {
+	Scope string `json:"scope" binding:"required"`
+	Name  string `json:"name" binding:"required"`
+	// Config 为灰度期间合并到配置上的值
+	Config     map[string]interface{} `json:"config" binding:"required"`
+	Clients    []string               `json:"clients"`
+	Percent    int                    `json:"percent"`
+	UpdateUser string                 `json:"update_user" binding:"required"`
+}
+
+// CreateRollout godoc
+// 创建灰度发布, 新值只下发给指定的客户端或按比例选中的已注册客户端
+func (s *Service) CreateRollout(c *gin.Context) {
+	var param RolloutParams
+	if err := c.ShouldBindJSON(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	s.Logger.Info("CreateRollout", "params", param)
+
+	if len(param.Config) == 0 {
+		SetHTTPResponse(c, -1, nil, "config 不能为空")
+		return
+	}
+
+	if param.Percent < 0 || param.Percent > 100 {
+		SetHTTPResponse(c, -1, nil, "percent 须在 0-100 之间")
+		return
+	}
+
+	if len(param.Clients) == 0 && param.Percent == 0 {
+		SetHTTPResponse(c, -1, nil, "clients 与 percent 不能同时为空")
+		return
+	}
+
+	// 与配置接口一致, 经过一次序列化得到 json 的值类型
+	value, err := json.Marshal(param.Config)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "参数格式错误")
+		return
+	}
+
+	var valueObject map[string]interface{}
+	if err := json.Unmarshal(value, &valueObject); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数格式错误")
+		return
+	}
+
+	rollout := &models.Rollout{
+		Scope:      param.Scope,
+		Name:       param.Name,
+		Value:      valueObject,
+		Clients:    param.Clients,
+		Percent:    param.Percent,
+		CreateUser: param.UpdateUser,
+	}
+	err = rollout.Create()
+	if errors.Is(err, sql.ErrNoRows) {
+		SetHTTPResponse(c, -1, nil, "配置不存在")
+		return
+	}
+	if errors.Is(err, models.ErrRolloutInProgress) {
+		SetHTTPResponse(c, -1, nil, "该配置已有进行中的灰度发布")
+		return
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "创建灰度发布失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rollout"] = rollout
+	SetHTTPResponse(c, 0, data, "创建成功")
+}
+
+type RolloutQueryParams struct {
+	Status string `form:"status" json:"status"`
+}
+
+// GetRollouts godoc
+func (s *Service) GetRollouts(c *gin.Context) {
+	var param RolloutQueryParams
+	if err := c.ShouldBindQuery(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	rollouts, err := models.GetRollouts(param.Status)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取灰度发布失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rollouts"] = rollouts
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
+
+type RolloutActionParams struct {
+	UpdateUser string `json:"update_user" binding:"required"`
+}
+
+// PromoteRollout godoc
+// 全量发布, 灰度值合并到配置中
+func (s *Service) PromoteRollout(c *gin.Context) {
+	rollout, param, ok := s.loadRolloutAction(c)
+	if !ok {
+		return
+	}
+
+	s.Logger.Info("PromoteRollout", "rollout", rollout.ID, "update_user", param.UpdateUser)
+
+	config, err := rollout.Promote(param.UpdateUser)
+	if errors.Is(err, models.ErrRolloutNotActive) {
+		SetHTTPResponse(c, -1, nil, "灰度发布已结束")
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		SetHTTPResponse(c, -1, nil, "配置不存在")
+		return
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "全量发布失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rollout"] = rollout
+	data["config"] = config
+	SetHTTPResponse(c, 0, data, "全量发布成功")
+}
+
+// AbortRollout godoc
+// 终止灰度, 灰度客户端恢复为当前配置
+func (s *Service) AbortRollout(c *gin.Context) {
+	rollout, param, ok := s.loadRolloutAction(c)
+	if !ok {
+		return
+	}
+
+	s.Logger.Info("AbortRollout", "rollout", rollout.ID, "update_user", param.UpdateUser)
+
+	err := rollout.Abort(param.UpdateUser)
+	if errors.Is(err, models.ErrRolloutNotActive) {
+		SetHTTPResponse(c, -1, nil, "灰度发布已结束")
+		return
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "终止灰度失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rollout"] = rollout
+	SetHTTPResponse(c, 0, data, "终止成功")
+}
+
+func (s *Service) loadRolloutAction(c *gin.Context) (*models.Rollout, *RolloutActionParams, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "id 参数错误")
+		return nil, nil, false
+	}
+
+	var param RolloutActionParams
+	if err := c.ShouldBindJSON(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return nil, nil, false
+	}
+
+	rollout, err := models.GetRollout(id)
+	if errors.Is(err, models.ErrRolloutNotFound) {
+		SetHTTPResponse(c, -1, nil, "灰度发布不存在")
+		return nil, nil, false
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取灰度发布失败: "+err.Error())
+		return nil, nil, false
+	}
+
+	return rollout, &param, true
+}
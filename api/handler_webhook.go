@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+
+	"quant_api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookParams struct {
+	URL        string   `json:"url" binding:"required"`
+	Secret     string   `json:"secret"`
+	Scopes     []string `json:"scopes"`
+	Names      []string `json:"names"`
+	Events     []string `json:"events"`
+	CreateUser string   `json:"create_user" binding:"required"`
+}
+
+// CreateWebhook godoc
+func (s *Service) CreateWebhook(c *gin.Context) {
+	var param WebhookParams
+	if err := c.ShouldBindJSON(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+
+	s.Logger.Info("CreateWebhook", "url", param.URL, "scopes", param.Scopes, "names", param.Names, "events", param.Events, "create_user", param.CreateUser)
+
+	u, err := url.Parse(param.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		SetHTTPResponse(c, -1, nil, "url 格式错误")
+		return
+	}
+
+	w := &models.Webhook{
+		URL:        param.URL,
+		Secret:     param.Secret,
+		Scopes:     param.Scopes,
+		Names:      param.Names,
+		Events:     param.Events,
+		CreateUser: param.CreateUser,
+	}
+	if err := w.Create(); err != nil {
+		SetHTTPResponse(c, -1, nil, "添加失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["webhook"] = w
+	SetHTTPResponse(c, 0, data, "添加成功")
+}
+
+// GetWebhooks godoc
+func (s *Service) GetWebhooks(c *gin.Context) {
+	webhooks, err := models.GetWebhooks()
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取 webhook 失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["webhooks"] = webhooks
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
+
+// DeleteWebhook godoc
+func (s *Service) DeleteWebhook(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "id 参数错误")
+		return
+	}
+
+	s.Logger.Info("DeleteWebhook", "id", id)
+
+	err = models.DeleteWebhook(id)
+	if errors.Is(err, models.ErrWebhookNotFound) {
+		SetHTTPResponse(c, -1, nil, "webhook 不存在")
+		return
+	}
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "删除失败: "+err.Error())
+		return
+	}
+
+	SetHTTPResponse(c, 0, nil, "删除成功")
+}
+
+type DeliveryQueryParams struct {
+	Status string `form:"status" json:"status"`
+	Limit  int    `form:"limit" json:"limit"`
+}
+
+// GetWebhookDeliveries godoc
+// 投递记录, 按时间倒序, 默认最近 100 条
+func (s *Service) GetWebhookDeliveries(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "id 参数错误")
+		return
+	}
+
+	var param DeliveryQueryParams
+	if err := c.ShouldBindQuery(&param); err != nil {
+		SetHTTPResponse(c, -1, nil, "参数错误")
+		return
+	}
+	if param.Limit <= 0 || param.Limit > 1000 {
+		param.Limit = 100
+	}
+
+	deliveries, err := models.GetWebhookDeliveries(id, param.Status, param.Limit)
+	if err != nil {
+		SetHTTPResponse(c, -1, nil, "获取投递记录失败: "+err.Error())
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["deliveries"] = deliveries
+	SetHTTPResponse(c, 0, data, "查询成功")
+}
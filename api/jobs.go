@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"quant_api/backend"
+	"quant_api/models"
+	"quant_api/outbox"
+	"quant_api/publisher"
+)
+
+// StartJobs 启动后台任务, 随 Close 退出
+func (s *Service) StartJobs() {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.done
+		cancel()
+	}()
+
+	go s.runEvery(time.Hour, s.purgeConfigTombstones)
+	go s.runEvery(time.Hour, s.purgeConfigOutbox)
+	go s.runEvery(closeOutReconcileInterval, s.reconcileCloseOuts)
+	go s.runEvery(time.Hour, s.purgeCloseOutConfirmations)
+	go s.runCloseOutWorkers(ctx)
+	go s.webhooks.Run(ctx)
+	for _, client := range s.backends {
+		go client.RunHealthChecks(ctx, s.Logger)
+	}
+
+	sinks := []outbox.Sink{outbox.NewLogSink(s.Logger), s.webhooks}
+	pub, err := publisher.New(s.cfg.Publisher)
+	if err != nil {
+		s.Logger.Error("create config publisher failed", "error", err)
+	} else if pub != nil {
+		sinks = append(sinks, publisher.NewSink(pub))
+		go func() {
+			<-ctx.Done()
+			pub.Close()
+		}()
+	}
+	go outbox.NewDispatcher(s.Logger, sinks...).Run(ctx)
+}
+
+// runEvery 周期执行 job, 直到服务关闭
+func (s *Service) runEvery(interval time.Duration, job func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	job()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			job()
+		}
+	}
+}
+
+func (s *Service) purgeConfigTombstones() {
+	retention := s.cfg.TombstoneRetention()
+	purged, err := models.PurgeConfigTombstones(retention)
+	if err != nil {
+		s.Logger.Error("purge config tombstones failed", "error", err)
+		return
+	}
+	if purged > 0 {
+		s.Logger.Info("purge config tombstones", "purged", purged, "retention", retention.String())
+	}
+}
+
+func (s *Service) purgeConfigOutbox() {
+	retention := s.cfg.OutboxRetention()
+	purged, err := models.PurgeOutboxMessages(retention)
+	if err != nil {
+		s.Logger.Error("purge config outbox failed", "error", err)
+		return
+	}
+	if purged > 0 {
+		s.Logger.Info("purge config outbox", "purged", purged, "retention", retention.String())
+	}
+}
+
+func (s *Service) purgeCloseOutConfirmations() {
+	purged, err := models.PurgeCloseOutConfirmations()
+	if err != nil {
+		s.Logger.Error("purge close out confirmations failed", "error", err)
+		return
+	}
+	if purged > 0 {
+		s.Logger.Info("purge close out confirmations", "purged", purged)
+	}
+}
+
+// 平仓任务的执行与核对
+const (
+	closeOutWorkers            = 4
+	closeOutQueueSize          = 1000
+	closeOutScanInterval       = 5 * time.Second
+	closeOutStaleAfter         = 2 * time.Minute
+	closeOutReconcileInterval  = 30 * time.Second
+	closeOutReconcileAttempts  = 20
+	closeOutReconcileBatchSize = 100
+)
+
+// runCloseOutWorkers 执行异步平仓任务, 新任务通过 closeOutQueue 立即执行,
+// 重启前未执行的任务由定时扫描补充. 创建后超过 CloseOutJobMaxAge 仍未执行的
+// 任务标记为失败, 需要重新发起.
+func (s *Service) runCloseOutWorkers(ctx context.Context) {
+	for i := 0; i < closeOutWorkers; i++ {
+		go s.closeOutWorker(ctx)
+	}
+
+	ticker := time.NewTicker(closeOutScanInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// 长时间未执行的任务不再执行, 避免故障恢复后执行过时的平仓
+		expired, err := models.MarkExpiredCloseOutJobs(s.cfg.CloseOutJobMaxAge())
+		if err != nil {
+			s.Logger.Error("mark expired close out jobs failed", "error", err)
+		} else if expired > 0 {
+			s.Logger.Warn("mark expired close out jobs failed", "count", expired)
+		}
+
+		records, err := models.GetPendingCloseOutJobs(closeOutQueueSize)
+		if err != nil {
+			s.Logger.Error("load pending close out jobs failed", "error", err)
+			continue
+		}
+		for _, record := range records {
+			select {
+			case s.closeOutQueue <- record.ID:
+			default:
+			}
+		}
+	}
+}
+
+func (s *Service) closeOutWorker(ctx context.Context) {
+	for {
+		var id int64
+		select {
+		case <-ctx.Done():
+			return
+		case id = <-s.closeOutQueue:
+		}
+
+		record, err := models.GetCloseOutRecord(id)
+		if err != nil {
+			s.Logger.Error("load close out job failed", "id", id, "error", err)
+			continue
+		}
+		if record.Status != models.CloseOutStatusPending {
+			continue
+		}
+
+		result := s.executeCloseOut(ctx, record)
+		s.Logger.Info("close out job finished", "id", id, "request_id", record.RequestID, "stock_code", record.StockCode,
+			"status", record.Status, "error", result.Error)
+	}
+}
+
+// reconcileCloseOuts 把长时间停在 sent 的记录标记为 unknown, 停在 pending 的
+// 同步记录标记为 failed, 并向 quant_core 查询 unknown 记录的结果
+func (s *Service) reconcileCloseOuts() {
+	marked, err := models.MarkStaleCloseOutRecords(closeOutStaleAfter)
+	if err != nil {
+		s.Logger.Error("mark stale close out records failed", "error", err)
+	} else if marked > 0 {
+		s.Logger.Warn("mark stale close out records unknown", "count", marked)
+	}
+
+	marked, err = models.MarkStalePendingCloseOutRecords(closeOutStaleAfter)
+	if err != nil {
+		s.Logger.Error("mark stale pending close out records failed", "error", err)
+	} else if marked > 0 {
+		s.Logger.Warn("mark stale pending close out records failed", "count", marked)
+	}
+
+	records, err := models.GetUnknownCloseOutRecords(closeOutReconcileAttempts, closeOutReconcileBatchSize)
+	if err != nil {
+		s.Logger.Error("load unknown close out records failed", "error", err)
+		return
+	}
+
+	for _, record := range records {
+		if !s.reconcileCloseOut(record) {
+			return
+		}
+	}
+}
+
+// reconcileCloseOut 返回 false 时 quant_core 不可用, 停止本轮核对
+func (s *Service) reconcileCloseOut(record *models.CloseOutRecord) bool {
+	record.ReconcileAttempts++
+
+	ctx := backend.WithRequestID(context.Background(), record.RequestID)
+	state, err := s.quantCore.GetCloseOut(ctx, record.RequestID, record.Endpoint)
+	if err != nil {
+		s.Logger.Warn("reconcile close out failed", "id", record.ID, "request_id", record.RequestID, "endpoint", record.Endpoint, "attempts", record.ReconcileAttempts, "error", err)
+		if err := record.Save(); err != nil {
+			s.Logger.Error("save close out record failed", "id", record.ID, "error", err)
+		}
+		return !errors.Is(err, backend.ErrQueryUnsupported) && !errors.Is(err, backend.ErrCircuitOpen)
+	}
+
+	switch state.Status {
+	case backend.CloseOutStateSucceeded:
+		setCloseOutInfo(record, state.Result)
+		record.Status = models.CloseOutStatusSucceeded
+		record.Message = state.Message
+		record.Error = ""
+	case backend.CloseOutStateFailed:
+		record.Status = models.CloseOutStatusFailed
+		record.Error = "计算模块处理异常， " + state.Message
+	case backend.CloseOutStateNotFound:
+		record.Status = models.CloseOutStatusFailed
+		record.Error = "计算模块未收到平仓请求"
+	default:
+		s.Logger.Warn("unexpected close out state", "id", record.ID, "request_id", record.RequestID, "state", state.Status)
+	}
+
+	s.Logger.Info("reconcile close out", "id", record.ID, "request_id", record.RequestID, "status", record.Status)
+	if err := record.Save(); err != nil {
+		s.Logger.Error("save close out record failed", "id", record.ID, "error", err)
+		return true
+	}
+	if record.Status != models.CloseOutStatusUnknown {
+		s.notifyCloseOut(record)
+	}
+	return true
+}
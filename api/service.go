@@ -5,6 +5,12 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
+
+	"quant_api/backend"
+	"quant_api/config"
+	"quant_api/confirm"
+	"quant_api/webhook"
 
 	"github.com/gin-gonic/gin"
 	sloggin "github.com/samber/slog-gin"
@@ -15,9 +21,18 @@ func init() {
 }
 
 type Service struct {
-	Logger *slog.Logger
-	cfg    *Config
-	isInit bool
+	Logger  *slog.Logger
+	cfg     *Config
+	isInit  bool
+	done    chan struct{}
+	started time.Time
+
+	webhooks  *webhook.Dispatcher
+	backends  map[string]*backend.Client
+	quantCore *backend.QuantCoreClient
+
+	closeOutQueue chan int64
+	confirmSigner *confirm.Signer
 
 	*http.Server
 
@@ -26,16 +41,38 @@ type Service struct {
 
 func CreateService(cfg *Config) *Service {
 	service := &Service{
-		isInit: false,
-		cfg:    cfg,
-		Logger: slog.Default(),
-		Engine: gin.New(),
+		isInit:  false,
+		cfg:     cfg,
+		done:    make(chan struct{}),
+		started: time.Now(),
+		Logger:  slog.Default(),
+
+		closeOutQueue: make(chan int64, closeOutQueueSize),
+		Engine:        gin.New(),
 	}
 
+	service.webhooks = webhook.NewDispatcher(service.Logger)
+	service.initBackends()
+	service.initCloseOutConfirm()
 	service.Init()
 	return service
 }
 
+// initBackends 为每个配置的后端创建客户端, quant_core 未配置时调用返回
+// backend.ErrNotConfigured
+func (s *Service) initBackends() {
+	s.backends = make(map[string]*backend.Client, len(s.cfg.Backend))
+	for name, cfg := range s.cfg.Backend {
+		s.backends[name] = backend.NewClient(name, cfg)
+	}
+
+	quantCore, ok := s.backends[backend.QuantCore]
+	if !ok {
+		quantCore = backend.NewClient(backend.QuantCore, config.Backend{})
+	}
+	s.quantCore = backend.NewQuantCoreClient(quantCore)
+}
+
 func (s *Service) Gin() *gin.Engine {
 	return s.Engine
 }
@@ -74,9 +111,9 @@ func Cors() gin.HandlerFunc {
 		origin := c.Request.Header.Get("Origin")
 		if origin != "" {
 			c.Header("Access-Control-Allow-Origin", origin)
-			c.Header("Access-Control-Allow-Headers", "Content-Type,AccessToken,X-CSRF-Token, Authorization, Token")
+			c.Header("Access-Control-Allow-Headers", "Content-Type,AccessToken,X-CSRF-Token, Authorization, Token, If-Match, Idempotency-Key, X-Request-Id")
 			c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, DELETE, PUT")
-			c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
+			c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type, ETag, X-Request-Id")
 			c.Header("Access-Control-Allow-Credentials", "true")
 			c.Header("Access-Control-Max-Age", "172800")
 		}
@@ -92,6 +129,7 @@ func Cors() gin.HandlerFunc {
 
 func (s *Service) WithLogger(log *slog.Logger) {
 	s.Logger = log.With("service", "http")
+	s.webhooks = webhook.NewDispatcher(s.Logger)
 }
 
 func (s *Service) Start() {
@@ -113,6 +151,8 @@ func (s *Service) Start() {
 
 	s.Logger.Info("HTTP Server is listen.", slog.String("Listen", s.Addr))
 
+	s.StartJobs()
+
 	s.Logger.Info("HTTP Server is started.")
 }
 
@@ -123,5 +163,6 @@ func (s *Service) ServiceAddress() string {
 // TODO: finish stop func
 func (s *Service) Close() {
 	s.Logger.Info("Stop HTTP Service.")
+	close(s.done)
 	return
 }
@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(2, time.Minute)
+	now := time.Unix(1000, 0)
+	b.now = func() time.Time { return now }
+
+	failure := errors.New("connection refused")
+	for i := 0; i < 2; i++ {
+		if err := b.Allow(); err != nil {
+			t.Fatalf("Allow() error = %v before threshold", err)
+		}
+		b.Failure(failure)
+	}
+	if got := b.Status().State; got != BreakerOpen {
+		t.Fatalf("state = %s, want open", got)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() error = %v, want ErrCircuitOpen", err)
+	}
+
+	// 断开超时后只放行一个探测请求, 探测失败重新断开
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() probe error = %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() during probe error = %v, want ErrCircuitOpen", err)
+	}
+	b.Failure(failure)
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() after failed probe error = %v, want ErrCircuitOpen", err)
+	}
+
+	// 调用方放弃的探测不计入, 可以再放行一个探测
+	now = now.Add(time.Minute)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() probe error = %v", err)
+	}
+	b.Cancel()
+	if err := b.Allow(); err != nil {
+		t.Fatalf("Allow() after canceled probe error = %v", err)
+	}
+	b.Success()
+	status := b.Status()
+	if status.State != BreakerClosed || status.Failures != 0 {
+		t.Errorf("status = %+v, want closed with no failures", status)
+	}
+}
@@ -0,0 +1,405 @@
+// Package backend 封装对 quant_core 等后端服务的 HTTP 调用. 所有客户端共用
+// 一个连接池, 每个后端有独立的超时、重试与熔断设置.
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"quant_api/config"
+)
+
+const (
+	defaultTimeout          = 10 * time.Second
+	defaultRetries          = 2
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+	defaultHealthInterval   = 5 * time.Second
+	retryBackoff            = 200 * time.Millisecond
+	maxResponseSize         = 10 << 20
+)
+
+var (
+	ErrNotConfigured = errors.New("backend: not configured")
+	// ErrUnknownEndpoint Request.Addr 不是配置的实例
+	ErrUnknownEndpoint = errors.New("backend: unknown endpoint")
+)
+
+// sharedTransport 所有后端客户端共用的连接池
+var sharedTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   20,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   5 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+const (
+	PolicyPrimaryStandby = "primary_standby"
+	PolicyRoundRobin     = "round_robin"
+)
+
+// Client 调用单个后端服务, 后端有多个实例时按策略选择健康的实例, 每个实例
+// 有独立的熔断器. 请求未发出时切换到下一个实例, 因此实例重启不会导致
+// 非幂等请求失败.
+type Client struct {
+	name      string
+	policy    string
+	endpoints []*endpoint
+	timeout   time.Duration
+	retries   int
+	client    *http.Client
+	proxy     []config.ProxyRule
+	// 代理请求的令牌
+	proxyTokens []string
+
+	healthPath     string
+	healthInterval time.Duration
+	next           atomic.Uint64
+}
+
+func NewClient(name string, cfg config.Backend) *Client {
+	c := &Client{
+		name:           name,
+		policy:         PolicyPrimaryStandby,
+		timeout:        defaultTimeout,
+		retries:        defaultRetries,
+		client:         &http.Client{Transport: sharedTransport},
+		proxy:          cfg.Proxy,
+		proxyTokens:    cfg.ProxyTokens,
+		healthPath:     cfg.HealthCheckPath,
+		healthInterval: defaultHealthInterval,
+	}
+	if cfg.Policy == PolicyRoundRobin {
+		c.policy = PolicyRoundRobin
+	}
+	if cfg.TimeoutSeconds > 0 {
+		c.timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	if cfg.Retries > 0 {
+		c.retries = cfg.Retries
+	}
+	if cfg.HealthCheckIntervalSeconds > 0 {
+		c.healthInterval = time.Duration(cfg.HealthCheckIntervalSeconds) * time.Second
+	}
+
+	threshold, openTimeout := defaultFailureThreshold, defaultOpenTimeout
+	if cfg.FailureThreshold > 0 {
+		threshold = cfg.FailureThreshold
+	}
+	if cfg.OpenSeconds > 0 {
+		openTimeout = time.Duration(cfg.OpenSeconds) * time.Second
+	}
+
+	endpoints := cfg.Endpoints
+	if len(endpoints) == 0 && cfg.Host != "" {
+		endpoints = []config.Endpoint{{Host: cfg.Host, Port: cfg.Port}}
+	}
+	for _, e := range endpoints {
+		c.endpoints = append(c.endpoints, &endpoint{
+			addr:         fmt.Sprintf("%s:%d", e.Host, e.Port),
+			breaker:      NewBreaker(threshold, openTimeout),
+			proxyBreaker: NewBreaker(threshold, openTimeout),
+			healthy:      true,
+		})
+	}
+
+	return c
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Addrs returns the address of every endpoint
+func (c *Client) Addrs() []string {
+	addrs := make([]string, 0, len(c.endpoints))
+	for _, e := range c.endpoints {
+		addrs = append(addrs, e.addr)
+	}
+	return addrs
+}
+
+// Addr returns the endpoint the next request prefers, empty when no endpoint
+// is configured
+func (c *Client) Addr() string {
+	if len(c.endpoints) == 0 {
+		return ""
+	}
+	for _, e := range c.endpoints {
+		if e.isHealthy() {
+			return e.addr
+		}
+	}
+	return c.endpoints[0].addr
+}
+
+// Request 一次后端调用, Body 序列化为 json, 为空时发送 RawBody. Idempotent
+// 的请求在连接失败、超时及 502/503/504 时重试, 其余请求只在连接未建立时重试,
+// 避免重复执行. Proxy 的请求来自 /backend/:name/*path 代理, 使用单独的熔断器.
+// Addr 非空时只发往该实例.
+type Request struct {
+	Method     string
+	Path       string
+	Query      url.Values
+	RawQuery   string
+	Header     http.Header
+	Body       interface{}
+	RawBody    []byte
+	Timeout    time.Duration
+	Idempotent bool
+	Proxy      bool
+	Addr       string
+}
+
+// Response 后端的响应, 非 2xx 时同样返回. Addr 为返回响应的实例.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Addr       string
+}
+
+// EndpointError 请求发往 Addr 实例后出错, 未读到响应
+type EndpointError struct {
+	Addr string
+	Err  error
+}
+
+func (e *EndpointError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EndpointError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorAddr returns the endpoint a failed request was sent to, empty when the
+// request did not reach any endpoint
+func ErrorAddr(err error) string {
+	var endpointErr *EndpointError
+	if errors.As(err, &endpointErr) {
+		return endpointErr.Addr
+	}
+	return ""
+}
+
+// Decode unmarshals the json body into v
+func (r *Response) Decode(v interface{}) error {
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		return &DecodeError{Err: err}
+	}
+	return nil
+}
+
+// DecodeError 响应不是预期的 json
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return "backend: decode response: " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Do sends the request, an error is returned only when no response is read
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	if len(c.endpoints) == 0 {
+		return nil, ErrNotConfigured
+	}
+
+	body := req.RawBody
+	if req.Body != nil {
+		var err error
+		if body, err = json.Marshal(req.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	// 每个实例至少尝试一次, 轮完一遍后再重试时等待
+	candidates := c.candidates()
+	if req.Addr != "" {
+		candidates = c.pinned(req.Addr)
+		if len(candidates) == 0 {
+			return nil, ErrUnknownEndpoint
+		}
+	}
+	attempts := c.retries + 1
+	if attempts < len(candidates) {
+		attempts = len(candidates)
+	}
+
+	var lastResp *Response
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if round := attempt / len(candidates); round > 0 && attempt%len(candidates) == 0 {
+			select {
+			case <-ctx.Done():
+				return lastResp, lastErr
+			case <-time.After(retryBackoff << (round - 1)):
+			}
+		}
+
+		e := candidates[attempt%len(candidates)]
+		breaker := e.breakerFor(req)
+		if err := breaker.Allow(); err != nil {
+			if lastResp == nil && lastErr == nil {
+				lastErr = err
+			}
+			continue
+		}
+
+		resp, err := c.roundTrip(ctx, e.addr, req, body)
+		if err != nil {
+			err = &EndpointError{Addr: e.addr, Err: err}
+		}
+		if err == nil && !unavailable(resp.StatusCode) {
+			breaker.Success()
+			return resp, nil
+		}
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// 调用方取消或超时, 不是后端的故障
+			breaker.Cancel()
+		case err != nil:
+			breaker.Failure(err)
+		default:
+			breaker.Failure(fmt.Errorf("status %d", resp.StatusCode))
+		}
+
+		lastResp, lastErr = resp, err
+		if ctx.Err() != nil || !retryable(req, err) {
+			return resp, err
+		}
+	}
+
+	return lastResp, lastErr
+}
+
+// candidates 按策略排列的实例, 健康的实例在前
+func (c *Client) candidates() []*endpoint {
+	n := len(c.endpoints)
+	start := 0
+	if c.policy == PolicyRoundRobin {
+		start = int((c.next.Add(1) - 1) % uint64(n))
+	}
+
+	healthy := make([]*endpoint, 0, n)
+	unhealthy := make([]*endpoint, 0)
+	for i := 0; i < n; i++ {
+		e := c.endpoints[(start+i)%n]
+		if e.isHealthy() {
+			healthy = append(healthy, e)
+		} else {
+			unhealthy = append(unhealthy, e)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// pinned returns the endpoint of addr
+func (c *Client) pinned(addr string) []*endpoint {
+	for _, e := range c.endpoints {
+		if e.addr == addr {
+			return []*endpoint{e}
+		}
+	}
+	return nil
+}
+
+func (c *Client) roundTrip(ctx context.Context, addr string, req *Request, body []byte) (*Response, error) {
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = c.timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	u := url.URL{Scheme: "http", Host: addr, Path: req.Path}
+	if req.Query != nil {
+		u.RawQuery = req.Query.Encode()
+	} else {
+		u.RawQuery = req.RawQuery
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Header {
+		httpReq.Header[k] = v
+	}
+	if req.Body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if requestID := RequestID(ctx); requestID != "" && httpReq.Header.Get(HeaderRequestID) == "" {
+		httpReq.Header.Set(HeaderRequestID, requestID)
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: respBody, Addr: addr}, nil
+}
+
+// unavailable 后端不可用的状态码, 计入熔断
+func unavailable(code int) bool {
+	return code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
+
+func retryable(req *Request, err error) bool {
+	return req.Idempotent || notSent(err)
+}
+
+// notSent 连接未建立, 请求一定没有发出
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Uncertain reports whether a failed request may still have been executed by
+// the backend, e.g. the connection broke or timed out after it was sent.
+func Uncertain(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var statusErr *StatusError
+	switch {
+	case errors.Is(err, ErrCircuitOpen), errors.Is(err, ErrNotConfigured), errors.Is(err, ErrUnknownEndpoint), errors.Is(err, ErrPreviewUnsupported):
+		return false
+	case errors.As(err, &statusErr):
+		return false
+	default:
+		return !notSent(err)
+	}
+}
@@ -0,0 +1,242 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"quant_api/config"
+)
+
+func newTestClient(t *testing.T, addr string) *Client {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("split %s: %v", addr, err)
+	}
+	p, _ := strconv.Atoi(port)
+	return NewClient("test", config.Backend{Host: host, Port: p, Retries: 2, FailureThreshold: 10})
+}
+
+func TestClientRetry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"message": "ok"}`))
+	}))
+	defer ts.Close()
+
+	client := newTestClient(t, ts.Listener.Addr().String())
+	ctx := context.Background()
+
+	// 非幂等请求收到响应后不重试
+	resp, err := client.Do(ctx, &Request{Method: http.MethodPost, Path: "/stock/close_out"})
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || calls != 1 {
+		t.Errorf("got status %d after %d calls, want 503 after 1", resp.StatusCode, calls)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	resp, err = client.Do(ctx, &Request{Method: http.MethodGet, Path: "/status", Idempotent: true})
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || calls != 2 {
+		t.Errorf("got status %d after %d calls, want 200 after 2", resp.StatusCode, calls)
+	}
+}
+
+func TestClientRetryDialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	client := newTestClient(t, addr)
+	_, err = client.Do(context.Background(), &Request{Method: http.MethodPost, Path: "/stock/close_out"})
+	if err == nil {
+		t.Fatalf("Do() error = nil, want dial error")
+	}
+	if Uncertain(err) {
+		t.Errorf("Uncertain(%v) = true, the request was never sent", err)
+	}
+	if got := client.Status().Endpoints[0].Breaker.Failures; got != 3 {
+		t.Errorf("failures = %d, want 3 attempts", got)
+	}
+}
+
+func TestClientCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	client := newTestClient(t, ts.Listener.Addr().String())
+	if _, err := client.Do(ctx, &Request{Method: http.MethodGet, Path: "/status", Idempotent: true}); err == nil {
+		t.Fatalf("Do() error = nil, want canceled")
+	}
+	if got := client.Status().Endpoints[0].Breaker.Failures; got != 0 {
+		t.Errorf("failures = %d, a canceled call is not a backend failure", got)
+	}
+}
+
+func TestClientProxyBreaker(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	host, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	client := NewClient("test", config.Backend{Host: host, Port: p, Retries: 1, FailureThreshold: 1})
+
+	// 代理请求的失败只熔断代理
+	client.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/status", Idempotent: true, Proxy: true})
+	status := client.Status().Endpoints[0]
+	if status.ProxyBreaker.State != BreakerOpen || status.Breaker.State != BreakerClosed {
+		t.Errorf("breaker = %s, proxy breaker = %s, want closed and open", status.Breaker.State, status.ProxyBreaker.State)
+	}
+	if _, err := client.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/status", Idempotent: true}); errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Do() error = %v, proxy failures opened the breaker", err)
+	}
+}
+
+func TestPreviewCloseOut(t *testing.T) {
+	var closedOut int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stock/close_out":
+			atomic.AddInt32(&closedOut, 1)
+			w.Write([]byte(`{"data": {"total_qty": 100}, "message": "ok"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	quantCore := NewQuantCoreClient(newTestClient(t, ts.Listener.Addr().String()))
+	if _, err := quantCore.PreviewCloseOut(context.Background(), "600000"); !errors.Is(err, ErrPreviewUnsupported) {
+		t.Errorf("PreviewCloseOut() error = %v, want ErrPreviewUnsupported", err)
+	}
+	if closedOut != 0 {
+		t.Errorf("preview executed the close out")
+	}
+}
+
+func TestCloseOutMethodNotAllowed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"message": "method not allowed"}`))
+	}))
+	defer ts.Close()
+
+	// 只有预览把 405 当作不支持, 平仓的 405 是 quant_core 的错误
+	quantCore := NewQuantCoreClient(newTestClient(t, ts.Listener.Addr().String()))
+	_, err := quantCore.CloseOut(context.Background(), "600000")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("CloseOut() error = %v, want StatusError 405", err)
+	}
+	if _, err := quantCore.PreviewCloseOut(context.Background(), "600000"); !errors.Is(err, ErrPreviewUnsupported) {
+		t.Errorf("PreviewCloseOut() error = %v, want ErrPreviewUnsupported", err)
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	primary := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": "ok"}`))
+	}))
+	defer ts.Close()
+	standby := ts.Listener.Addr().(*net.TCPAddr)
+
+	client := NewClient("test", config.Backend{
+		Endpoints: []config.Endpoint{
+			{Host: "127.0.0.1", Port: primary.Port},
+			{Host: "127.0.0.1", Port: standby.Port},
+		},
+		Retries: 1,
+	})
+
+	// 主实例重启时非幂等请求切换到备实例
+	resp, err := client.Do(context.Background(), &Request{Method: http.MethodPost, Path: "/stock/close_out"})
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want 200", resp.StatusCode)
+	}
+
+	client.CheckHealth(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	status := client.Status()
+	if status.Endpoints[0].Healthy || !status.Endpoints[1].Healthy {
+		t.Errorf("endpoint health = %v, %v, want false, true", status.Endpoints[0].Healthy, status.Endpoints[1].Healthy)
+	}
+	if status.Current != standby.String() {
+		t.Errorf("Current = %s, want %s", status.Current, standby)
+	}
+	if resp.Addr != standby.String() {
+		t.Errorf("Addr = %s, want %s", resp.Addr, standby)
+	}
+}
+
+func TestGetCloseOut(t *testing.T) {
+	newServer := func(state string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"data": {"status": "` + state + `"}, "message": "ok"}`))
+		}))
+	}
+	other := newServer(CloseOutStateNotFound)
+	defer other.Close()
+	sent := newServer(CloseOutStateSucceeded)
+	defer sent.Close()
+
+	var endpoints []config.Endpoint
+	for _, ts := range []*httptest.Server{other, sent} {
+		addr := ts.Listener.Addr().(*net.TCPAddr)
+		endpoints = append(endpoints, config.Endpoint{Host: "127.0.0.1", Port: addr.Port})
+	}
+	quantCore := NewQuantCoreClient(NewClient(QuantCore, config.Backend{Endpoints: endpoints}))
+	ctx := context.Background()
+
+	// 只有收到请求的实例知道结果
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{other.Listener.Addr().String(), CloseOutStateNotFound},
+		{sent.Listener.Addr().String(), CloseOutStateSucceeded},
+		{"", CloseOutStateSucceeded},
+		{"127.0.0.1:1", CloseOutStateSucceeded},
+	}
+	for _, tt := range tests {
+		state, err := quantCore.GetCloseOut(ctx, "req-1", tt.addr)
+		if err != nil {
+			t.Fatalf("GetCloseOut(%q) error = %v", tt.addr, err)
+		}
+		if state.Status != tt.want {
+			t.Errorf("GetCloseOut(%q) = %s, want %s", tt.addr, state.Status, tt.want)
+		}
+	}
+}
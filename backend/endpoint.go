@@ -0,0 +1,191 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// endpoint 后端的一个实例, 健康状态由 RunHealthChecks 定期更新. 代理的请求
+// 使用单独的熔断器, 客户端的请求失败不会熔断本服务自身的调用.
+type endpoint struct {
+	addr         string
+	breaker      *Breaker
+	proxyBreaker *Breaker
+
+	mu        sync.Mutex
+	healthy   bool
+	checkedAt time.Time
+	latency   time.Duration
+	lastError string
+}
+
+func (e *endpoint) breakerFor(req *Request) *Breaker {
+	if req.Proxy {
+		return e.proxyBreaker
+	}
+	return e.breaker
+}
+
+func (e *endpoint) isHealthy() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy
+}
+
+// setHealth records a check result, returns true when the health changed
+func (e *endpoint) setHealth(err error, latency time.Duration) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	healthy := err == nil
+	changed := healthy != e.healthy
+	e.healthy = healthy
+	e.checkedAt = time.Now()
+	e.latency = latency
+	e.lastError = ""
+	if err != nil {
+		e.lastError = err.Error()
+	}
+	return changed
+}
+
+// EndpointStatus 实例的健康与熔断状态, Role 在 primary_standby 策略下为
+// primary 或 standby
+type EndpointStatus struct {
+	Addr      string        `json:"addr"`
+	Role      string        `json:"role,omitempty"`
+	Healthy   bool          `json:"healthy"`
+	CheckedAt int64         `json:"checked_at,omitempty"`
+	LatencyMs float64       `json:"latency_ms"`
+	LastError string        `json:"last_error,omitempty"`
+	Breaker   BreakerStatus `json:"breaker"`
+	// 代理请求的熔断状态, 不影响 Healthy
+	ProxyBreaker BreakerStatus `json:"proxy_breaker"`
+}
+
+// Status 后端当前使用的实例与各实例状态
+type Status struct {
+	Name      string            `json:"name"`
+	Policy    string            `json:"policy"`
+	Current   string            `json:"current"`
+	Healthy   bool              `json:"healthy"`
+	Endpoints []*EndpointStatus `json:"endpoints"`
+}
+
+func (c *Client) Status() *Status {
+	status := &Status{
+		Name:      c.name,
+		Policy:    c.policy,
+		Current:   c.Addr(),
+		Endpoints: make([]*EndpointStatus, 0, len(c.endpoints)),
+	}
+
+	for i, e := range c.endpoints {
+		e.mu.Lock()
+		endpointStatus := &EndpointStatus{
+			Addr:      e.addr,
+			Healthy:   e.healthy,
+			LatencyMs: float64(e.latency.Microseconds()) / 1000,
+			LastError: e.lastError,
+		}
+		if !e.checkedAt.IsZero() {
+			endpointStatus.CheckedAt = e.checkedAt.Unix()
+		}
+		e.mu.Unlock()
+
+		endpointStatus.Breaker = e.breaker.Status()
+		endpointStatus.ProxyBreaker = e.proxyBreaker.Status()
+		if c.policy == PolicyPrimaryStandby {
+			endpointStatus.Role = "standby"
+			if i == 0 {
+				endpointStatus.Role = "primary"
+			}
+		}
+		if endpointStatus.Healthy && endpointStatus.Breaker.State != BreakerOpen {
+			status.Healthy = true
+		}
+		status.Endpoints = append(status.Endpoints, endpointStatus)
+	}
+
+	return status
+}
+
+// RunHealthChecks checks every endpoint periodically until ctx is done.
+// An endpoint is checked with GET health_check_path when configured, or by
+// opening a TCP connection otherwise.
+func (c *Client) RunHealthChecks(ctx context.Context, logger *slog.Logger) {
+	if len(c.endpoints) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		c.CheckHealth(ctx, logger)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckHealth checks every endpoint once
+func (c *Client) CheckHealth(ctx context.Context, logger *slog.Logger) {
+	var wg sync.WaitGroup
+	for _, e := range c.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := c.check(ctx, e.addr)
+			if e.setHealth(err, time.Since(start)) {
+				if err != nil {
+					logger.Warn("backend endpoint unhealthy", "backend", c.name, "addr", e.addr, "error", err)
+				} else {
+					logger.Info("backend endpoint healthy", "backend", c.name, "addr", e.addr)
+				}
+			}
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (c *Client) check(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	if c.healthPath == "" {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+c.healthPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("health check status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package backend
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"path"
+	"strings"
+
+	"quant_api/config"
+)
+
+// 转发给后端的请求头, 其余请求头(如 Authorization, Cookie)不转发
+var proxyRequestHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Content-Type",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+}
+
+// 不返回给调用方的响应头, 包括逐跳头部与后端的 cookie
+var proxyDroppedResponseHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+	"Set-Cookie":          true,
+}
+
+// ProxyAuthorized reports whether token is one of the backend's proxy tokens,
+// no request is authorized when none is configured
+func (c *Client) ProxyAuthorized(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, proxyToken := range c.proxyTokens {
+		if proxyToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(proxyToken)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyAllowed reports whether the backend allows proxying the request, p must
+// be cleaned by CleanProxyPath
+func (c *Client) ProxyAllowed(method, p string) bool {
+	for _, rule := range c.proxy {
+		if proxyMethodAllowed(rule, method) && proxyPathAllowed(rule, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func proxyMethodAllowed(rule config.ProxyRule, method string) bool {
+	if len(rule.Methods) == 0 {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	for _, m := range rule.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func proxyPathAllowed(rule config.ProxyRule, p string) bool {
+	for _, pattern := range rule.Paths {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(p, prefix) {
+				return true
+			}
+		} else if p == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanProxyPath resolves "." and ".." so that the allowlist can not be
+// bypassed
+func CleanProxyPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// ProxyRequestHeader returns the headers of in that may be sent to a backend
+func ProxyRequestHeader(in http.Header) http.Header {
+	out := make(http.Header)
+	for _, k := range proxyRequestHeaders {
+		if v := in.Values(k); len(v) > 0 {
+			out[k] = append([]string(nil), v...)
+		}
+	}
+	return out
+}
+
+// CopyProxyResponseHeader copies the backend response headers that may be
+// returned to the caller
+func CopyProxyResponseHeader(dst, src http.Header) {
+	for k, v := range src {
+		if proxyDroppedResponseHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		dst[k] = append([]string(nil), v...)
+	}
+}
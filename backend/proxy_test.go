@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"net/http"
+	"testing"
+
+	"quant_api/config"
+)
+
+func TestProxyAllowed(t *testing.T) {
+	client := NewClient("quant_core", config.Backend{
+		Host: "localhost",
+		Port: 4321,
+		Proxy: []config.ProxyRule{
+			{Paths: []string{"/stock/positions", "/stock/tasks/*"}},
+			{Methods: []string{"post"}, Paths: []string{"/stock/refresh"}},
+		},
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{http.MethodGet, "/stock/positions", true},
+		{http.MethodHead, "/stock/positions", true},
+		{http.MethodPost, "/stock/positions", false},
+		{http.MethodGet, "/stock/positions/600000", false},
+		{http.MethodGet, "/stock/tasks/600000", true},
+		{http.MethodGet, "/stock/tasks/../close_out", false},
+		{http.MethodPost, "/stock/refresh", true},
+		{http.MethodPost, "/stock/close_out", false},
+	}
+	for _, tt := range tests {
+		if got := client.ProxyAllowed(tt.method, CleanProxyPath(tt.path)); got != tt.want {
+			t.Errorf("ProxyAllowed(%s, %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestProxyAuthorized(t *testing.T) {
+	client := NewClient("quant_core", config.Backend{Host: "localhost", Port: 4321, ProxyTokens: []string{"secret"}})
+	for token, want := range map[string]bool{"secret": true, "other": false, "": false} {
+		if got := client.ProxyAuthorized(token); got != want {
+			t.Errorf("ProxyAuthorized(%q) = %v, want %v", token, got, want)
+		}
+	}
+
+	if NewClient("quant_core", config.Backend{Host: "localhost", Port: 4321}).ProxyAuthorized("secret") {
+		t.Errorf("ProxyAuthorized() = true without proxy tokens")
+	}
+}
+
+func TestProxyRequestHeader(t *testing.T) {
+	in := http.Header{}
+	in.Set("Accept", "application/json")
+	in.Set("Authorization", "Bearer secret")
+	in.Set("Cookie", "session=1")
+
+	out := ProxyRequestHeader(in)
+	if out.Get("Accept") != "application/json" {
+		t.Errorf("Accept is not forwarded")
+	}
+	if out.Get("Authorization") != "" || out.Get("Cookie") != "" {
+		t.Errorf("credentials are forwarded: %v", out)
+	}
+}
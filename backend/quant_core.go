@@ -0,0 +1,170 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const QuantCore = "quant_core"
+
+// CloseOutResponse quant_core 平仓接口的响应, Addr 为处理请求的实例
+type CloseOutResponse struct {
+	Data    CloseOutInfo `json:"data"`
+	Message string       `json:"message"`
+	Addr    string       `json:"-"`
+}
+
+type CloseOutInfo struct {
+	LastPrice          float64     `json:"price"`
+	CanceledEnterTasks []int       `json:"canceled_enter_tasks"`
+	CanceledExitTasks  []int       `json:"canceled_exit_tasks"`
+	CloseOutQty        map[int]int `json:"close_out_qty"`
+	TotalQty           int         `json:"total_qty"`
+}
+
+var (
+	// ErrPreviewUnsupported quant_core 没有平仓预览接口
+	ErrPreviewUnsupported = errors.New("quant_core: close out preview is not supported")
+	// ErrQueryUnsupported quant_core 没有按请求 id 查询平仓结果的接口
+	ErrQueryUnsupported = errors.New("quant_core: close out query is not supported")
+)
+
+// quant_core 按请求 id 查询到的平仓状态
+const (
+	CloseOutStateSucceeded = "succeeded"
+	CloseOutStateFailed    = "failed"
+	CloseOutStateNotFound  = "not_found"
+)
+
+// CloseOutState 按 X-Request-Id 查询到的平仓结果, NotFound 表示 quant_core
+// 没有收到该请求
+type CloseOutState struct {
+	Status  string       `json:"status"`
+	Result  CloseOutInfo `json:"result"`
+	Message string       `json:"message"`
+}
+
+// StatusError quant_core 返回了非 200 的状态码
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("quant_core: status %d: %s", e.StatusCode, e.Message)
+}
+
+// QuantCoreClient quant_core 的接口
+type QuantCoreClient struct {
+	*Client
+}
+
+func NewQuantCoreClient(client *Client) *QuantCoreClient {
+	return &QuantCoreClient{Client: client}
+}
+
+// CloseOut 平仓, 非 200 时返回解析出的响应与 *StatusError
+func (q *QuantCoreClient) CloseOut(ctx context.Context, stockCode string) (*CloseOutResponse, error) {
+	return q.closeOut(ctx, &Request{
+		Method: http.MethodPost,
+		Path:   "/stock/close_out",
+		Body:   map[string]interface{}{"stock_code": stockCode},
+	}, false)
+}
+
+// PreviewCloseOut 返回平仓将撤销的任务与平仓数量, 不实际执行. 预览使用单独的
+// 接口, 不支持预览的 quant_core 返回 404 而不会误执行平仓.
+func (q *QuantCoreClient) PreviewCloseOut(ctx context.Context, stockCode string) (*CloseOutResponse, error) {
+	return q.closeOut(ctx, &Request{
+		Method:     http.MethodPost,
+		Path:       "/stock/close_out/preview",
+		Body:       map[string]interface{}{"stock_code": stockCode, "dry_run": true},
+		Idempotent: true,
+	}, true)
+}
+
+func (q *QuantCoreClient) closeOut(ctx context.Context, req *Request, preview bool) (*CloseOutResponse, error) {
+	resp, err := q.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if preview && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed) {
+		return nil, ErrPreviewUnsupported
+	}
+
+	closeOutResponse := CloseOutResponse{Addr: resp.Addr}
+	if err := resp.Decode(&closeOutResponse); err != nil {
+		return nil, &EndpointError{Addr: resp.Addr, Err: err}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &closeOutResponse, &StatusError{StatusCode: resp.StatusCode, Message: closeOutResponse.Message}
+	}
+
+	return &closeOutResponse, nil
+}
+
+// GetCloseOut 按平仓时发送的 X-Request-Id 查询结果, 用于核对未收到响应的平仓.
+// 各实例只记录自己收到的请求, addr 为平仓请求发往的实例; addr 为空或已不在
+// 配置中时查询所有实例, 所有实例都返回 not_found 时才返回 not_found.
+func (q *QuantCoreClient) GetCloseOut(ctx context.Context, requestID, addr string) (*CloseOutState, error) {
+	if addr != "" {
+		state, err := q.getCloseOut(ctx, requestID, addr)
+		if !errors.Is(err, ErrUnknownEndpoint) {
+			return state, err
+		}
+	}
+
+	var notFound *CloseOutState
+	var lastErr error
+	for _, addr := range q.Addrs() {
+		state, err := q.getCloseOut(ctx, requestID, addr)
+		switch {
+		case err != nil:
+			lastErr = err
+		case state.Status == CloseOutStateNotFound:
+			notFound = state
+		default:
+			return state, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	if notFound == nil {
+		return nil, ErrNotConfigured
+	}
+	return notFound, nil
+}
+
+func (q *QuantCoreClient) getCloseOut(ctx context.Context, requestID, addr string) (*CloseOutState, error) {
+	resp, err := q.Do(ctx, &Request{
+		Method:     http.MethodGet,
+		Path:       "/stock/close_out/requests/" + url.PathEscape(requestID),
+		Idempotent: true,
+		Addr:       addr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, ErrQueryUnsupported
+	}
+
+	var stateResponse struct {
+		Data    CloseOutState `json:"data"`
+		Message string        `json:"message"`
+	}
+	if err := resp.Decode(&stateResponse); err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Message: stateResponse.Message}
+	}
+
+	return &stateResponse.Data, nil
+}
@@ -0,0 +1,18 @@
+package backend
+
+import "context"
+
+// HeaderRequestID 透传给后端的请求 id, 便于跨服务排查
+const HeaderRequestID = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose backend calls carry the request id
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
@@ -21,6 +22,10 @@ func InitGlobalConfig(configFile string) {
 		fmt.Println("read config file error,", err)
 		os.Exit(1)
 	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Println("invalid config,", err)
+		os.Exit(1)
+	}
 	globalConfig = cfg
 	fmt.Println("Global Config:", globalConfig.String())
 }
@@ -43,22 +48,137 @@ type Config struct {
 		Password string `json:"password"`
 		Database string `json:"database"`
 	}
-	Backend map[string]struct {
-		Host string `json:"host"`
-		Port int    `json:"port"`
+	Backend      map[string]Backend
+	ConfigCenter ConfigCenter `json:"config_center"`
+	Publisher    Publisher    `json:"publisher"`
+	CloseOut     CloseOut     `json:"close_out"`
+}
+
+// Backend 后端服务地址与调用设置, 未设置的项使用默认值. 配置了 Endpoints
+// 时忽略 Host 与 Port.
+type Backend struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// 多个实例的地址, 按 Policy 选择
+	Endpoints []Endpoint `json:"endpoints"`
+	// primary_standby(默认): 优先使用第一个健康的实例; round_robin: 轮流使用健康的实例
+	Policy string `json:"policy"`
+	// 健康检查请求的路径, 为空时只检查 TCP 连接
+	HealthCheckPath string `json:"health_check_path"`
+	// 健康检查间隔(秒), 默认 5
+	HealthCheckIntervalSeconds int `json:"health_check_interval_seconds"`
+	// 单次请求超时(秒), 默认 10
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// 失败后的重试次数, 默认 2, 非幂等请求只在连接失败时重试
+	Retries int `json:"retries"`
+	// 连续失败多少次后熔断, 默认 5
+	FailureThreshold int `json:"failure_threshold"`
+	// 熔断后多久(秒)放行探测请求, 默认 30
+	OpenSeconds int `json:"open_seconds"`
+	// 允许通过 /backend/:name/*path 代理的请求, 为空时不允许代理
+	Proxy []ProxyRule `json:"proxy"`
+	// 代理请求需以 Authorization: Bearer <token> 携带其中之一, 为空时不允许代理
+	ProxyTokens []string `json:"proxy_tokens"`
+}
+
+type Endpoint struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// ProxyRule Methods 为空时只允许 GET 与 HEAD. Paths 以 * 结尾时按前缀匹配,
+// 否则需完全一致
+type ProxyRule struct {
+	Methods []string `json:"methods"`
+	Paths   []string `json:"paths"`
+}
+
+type ConfigCenter struct {
+	// 已删除配置的墓碑保留时长(小时), 默认 168
+	TombstoneRetentionHours int `json:"tombstone_retention_hours"`
+	// 客户端超过该时长(秒)未同步也未心跳时标记为失联, 默认 120
+	StaleAfterSeconds int `json:"stale_after_seconds"`
+	// 已投递的变更事件在 config_outbox 中的保留时长(小时), 默认 72
+	OutboxRetentionHours int `json:"outbox_retention_hours"`
+	// 变更事件积压超过该时长(秒)时 /readyz 报告配置中心降级, 默认 60
+	MaxLagSeconds int `json:"max_lag_seconds"`
+}
+
+// Publisher 配置变更发布到的消息总线, Type 为空时不发布
+type Publisher struct {
+	// loopback 或 redis
+	Type     string `json:"type"`
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	// 默认 quant_api.config
+	Channel        string `json:"channel"`
+	TimeoutSeconds int    `json:"timeout_seconds"`
+}
+
+// CloseOut 平仓确认设置, 各环境可分别配置
+type CloseOut struct {
+	// 为 true 时平仓需两步确认: 先取得确认令牌, 再携带令牌执行
+	RequireConfirmation bool `json:"require_confirmation"`
+	// 确认令牌的签名密钥, 多实例部署时需一致. 开启确认时必须配置, 未开启时
+	// 为空则每次启动随机生成
+	ConfirmationSecret string `json:"confirmation_secret"`
+	// 确认令牌有效期(秒), 默认 60
+	ConfirmationTTLSeconds int `json:"confirmation_ttl_seconds"`
+	// 以 Authorization: Bearer <token> 携带时可单步平仓, 供自动化程序使用
+	PrivilegedTokens []string `json:"privileged_tokens"`
+	// 异步平仓任务创建后超过该时长(秒)仍未执行时不再执行并标记为失败, 默认 60
+	JobMaxAgeSeconds int `json:"job_max_age_seconds"`
+}
+
+// Validate checks the settings that can not fall back to a default
+func (c *Config) Validate() error {
+	// 随机密钥签发的令牌只在本进程内有效, 多实例或重启后确认会失败
+	if c.CloseOut.RequireConfirmation && c.CloseOut.ConfirmationSecret == "" {
+		return errors.New("close_out.confirmation_secret is required when require_confirmation is true")
 	}
+	return nil
 }
 
 func (c *Config) ToMap() map[string]interface{} {
 	return StructToMap(c)
 }
 
+// 打印配置时代替密码与令牌
+const maskedSecret = "******"
+
+// String 密码、确认密钥与各类令牌以 maskedSecret 代替, 启动时会打印到日志
 func (c *Config) String() string {
+	masked := *c
+	masked.Database.Password = mask(c.Database.Password)
+	masked.Publisher.Password = mask(c.Publisher.Password)
+	masked.CloseOut.ConfirmationSecret = mask(c.CloseOut.ConfirmationSecret)
+	masked.CloseOut.PrivilegedTokens = maskAll(c.CloseOut.PrivilegedTokens)
+	masked.Backend = make(map[string]Backend, len(c.Backend))
+	for name, backend := range c.Backend {
+		backend.ProxyTokens = maskAll(backend.ProxyTokens)
+		masked.Backend[name] = backend
+	}
+
 	// use json.Marshal to convert struct to string
-	b, _ := json.Marshal(c)
+	b, _ := json.Marshal(&masked)
 	return string(pretty.Pretty(b))
 }
 
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return maskedSecret
+}
+
+func maskAll(secrets []string) []string {
+	masked := make([]string, len(secrets))
+	for i, secret := range secrets {
+		masked[i] = mask(secret)
+	}
+	return masked
+}
+
 // transform Config object to map[string]string
 func StructToMap(obj interface{}) map[string]interface{} {
 	result := make(map[string]interface{})
@@ -0,0 +1,614 @@
+// Package configclient 是配置中心的 Go 客户端, 在本地内存中缓存配置并通过
+// /config_center/* 接口增量同步, 同时把快照持久化到磁盘, quant_api 不可用时
+// 进程仍可使用上次的配置启动.
+package configclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ScopeStock  = "stock"
+	ScopeGlobal = "global"
+)
+
+type Options struct {
+	// Addr quant_api 地址, 如 http://quant_api:4320
+	Addr        string
+	ClientID    string
+	Name        string
+	Host        string
+	Version     string
+	Environment string
+	// Scopes 与 Subscriptions 为要同步的配置, 都为空时同步全部配置
+	Scopes        []string
+	Subscriptions []Subscription
+
+	// SnapshotPath 本地快照文件, 为空时不持久化
+	SnapshotPath string
+	// Wait 长轮询等待时长, 默认 30s
+	Wait time.Duration
+	// HeartbeatInterval 心跳间隔, 默认 30s
+	HeartbeatInterval time.Duration
+
+	HTTPClient *http.Client
+	Logger     *slog.Logger
+}
+
+// Change 描述一次配置变更, Old 为空表示新增, New.Deleted 为 true 表示删除
+type Change struct {
+	Old *Config
+	New *Config
+}
+
+type Client struct {
+	opts   Options
+	http   *http.Client
+	logger *slog.Logger
+
+	mu        sync.RWMutex
+	configs   map[string]*Config
+	cursor    int64
+	callbacks []func(Change)
+	// ackedCursor 服务端已确认的游标, 落后于 cursor 时下次同步重新确认
+	ackedCursor int64
+
+	registered bool
+	// resync 服务端要求重新拉取快照, 见 Register
+	resync bool
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func New(opts Options) (*Client, error) {
+	if opts.Addr == "" {
+		return nil, errors.New("addr is empty")
+	}
+	if opts.ClientID == "" {
+		return nil, errors.New("client id is empty")
+	}
+	if opts.Wait <= 0 {
+		opts.Wait = 30 * time.Second
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = 30 * time.Second
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: opts.Wait + 10*time.Second}
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	opts.Addr = strings.TrimRight(opts.Addr, "/")
+
+	return &Client{
+		opts:    opts,
+		http:    opts.HTTPClient,
+		logger:  opts.Logger.With("component", "configclient"),
+		configs: make(map[string]*Config),
+	}, nil
+}
+
+// Start 加载本地快照, 注册客户端并启动后台同步与心跳. 快照加载成功时即使
+// quant_api 不可用也不会返回错误, 后台会持续重试.
+func (c *Client) Start(ctx context.Context) error {
+	loaded := false
+	if c.opts.SnapshotPath != "" {
+		if err := c.LoadSnapshot(); err != nil {
+			c.logger.Warn("load snapshot failed", "path", c.opts.SnapshotPath, "error", err)
+		} else {
+			loaded = true
+		}
+	}
+
+	if err := c.Register(ctx); err != nil {
+		if !loaded {
+			return err
+		}
+		c.logger.Warn("register failed, start with snapshot", "error", err)
+	} else if !loaded || c.needResync() {
+		if err := c.Bootstrap(ctx); err != nil {
+			return err
+		}
+	} else if err := c.Sync(ctx, 0); err != nil {
+		c.logger.Warn("initial sync failed, start with snapshot", "error", err)
+	} else if c.needResync() {
+		// 快照的游标早于服务端已清理的删除记录
+		if err := c.Bootstrap(ctx); err != nil {
+			c.logger.Warn("resync failed, start with snapshot", "error", err)
+		}
+	}
+
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.wg.Add(2)
+	go c.syncLoop(ctx)
+	go c.heartbeatLoop(ctx)
+
+	return nil
+}
+
+// Close 停止后台任务
+func (c *Client) Close() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+}
+
+// OnChange 注册变更回调, 回调在同步协程中按变更顺序执行
+func (c *Client) OnChange(callback func(Change)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.callbacks = append(c.callbacks, callback)
+}
+
+func (c *Client) Cursor() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cursor
+}
+
+// Get 返回配置的副本
+func (c *Client) Get(scope, name string) (*Config, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	config, ok := c.configs[configKey(scope, name)]
+	if !ok {
+		return nil, false
+	}
+	copied := *config
+	return &copied, true
+}
+
+// Configs 返回 scope 下所有配置
+func (c *Client) Configs(scope string) []*Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	configs := make([]*Config, 0)
+	for _, config := range c.configs {
+		if config.Scope == scope {
+			copied := *config
+			configs = append(configs, &copied)
+		}
+	}
+	return configs
+}
+
+func (c *Client) ProdStatus(stockCode string) (bool, bool) {
+	return c.boolValue(ScopeStock, stockCode, "prod_status")
+}
+
+func (c *Client) PreStatus(stockCode string) (bool, bool) {
+	return c.boolValue(ScopeStock, stockCode, "pre_status")
+}
+
+func (c *Client) UpLimit(stockCode string) (float64, bool) {
+	return c.floatValue(ScopeStock, stockCode, "up_limit")
+}
+
+func (c *Client) LowLimit(stockCode string) (float64, bool) {
+	return c.floatValue(ScopeStock, stockCode, "low_limit")
+}
+
+func (c *Client) Broker(name string) (string, bool) {
+	v, ok := c.value(ScopeGlobal, name, "broker")
+	if !ok {
+		return "", false
+	}
+	broker, ok := v.(string)
+	return broker, ok
+}
+
+func (c *Client) value(scope, name, key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	config, ok := c.configs[configKey(scope, name)]
+	if !ok {
+		return nil, false
+	}
+	v, ok := config.Value[key]
+	return v, ok
+}
+
+func (c *Client) boolValue(scope, name, key string) (bool, bool) {
+	v, ok := c.value(scope, name, key)
+	if !ok {
+		return false, false
+	}
+	b, ok := v.(bool)
+	return b, ok
+}
+
+func (c *Client) floatValue(scope, name, key string) (float64, bool) {
+	v, ok := c.value(scope, name, key)
+	if !ok {
+		return 0, false
+	}
+	f, ok := v.(float64)
+	return f, ok
+}
+
+type registerRequest struct {
+	ClientID    string   `json:"client_id"`
+	Name        string   `json:"name"`
+	Host        string   `json:"host"`
+	Version     string   `json:"version"`
+	Environment string   `json:"environment"`
+	Scopes      []string `json:"scopes"`
+
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+type registerResponse struct {
+	Client struct {
+		Resync bool `json:"resync"`
+	} `json:"client"`
+}
+
+// Register 注册客户端. 订阅范围扩大时游标之前新订阅的配置不会再增量下发,
+// 缩小时缓存中不再订阅的配置不会被删除, 服务端要求重新拉取快照, 之后的同步
+// 会先执行 Bootstrap, 以快照替换缓存.
+func (c *Client) Register(ctx context.Context) error {
+	req := registerRequest{
+		ClientID:    c.opts.ClientID,
+		Name:        c.opts.Name,
+		Host:        c.opts.Host,
+		Version:     c.opts.Version,
+		Environment: c.opts.Environment,
+		Scopes:      c.opts.Scopes,
+
+		Subscriptions: c.opts.Subscriptions,
+	}
+	var resp registerResponse
+	if err := c.do(ctx, http.MethodPost, "/config_center/register", nil, req, &resp); err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+
+	c.mu.Lock()
+	c.registered = true
+	c.resync = c.resync || resp.Client.Resync
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) needResync() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.resync
+}
+
+func (c *Client) Heartbeat(ctx context.Context) error {
+	req := map[string]string{"client_id": c.opts.ClientID}
+	if err := c.do(ctx, http.MethodPost, "/config_center/heartbeat", nil, req, nil); err != nil {
+		return fmt.Errorf("heartbeat: %w", err)
+	}
+	return nil
+}
+
+// syncResponse Resync 为 true 时游标之后的删除记录已在服务端清理, 需要重新
+// 拉取快照
+type syncResponse struct {
+	Configs []*Config `json:"configs"`
+	Cursor  int64     `json:"cursor"`
+	Resync  bool      `json:"resync"`
+}
+
+// Sync 从当前游标增量同步一次, wait 大于 0 时使用长轮询. 变更应用后向服务端
+// 确认已应用的游标.
+func (c *Client) Sync(ctx context.Context, wait time.Duration) error {
+	_, err := c.sync(ctx, wait)
+	return err
+}
+
+func (c *Client) sync(ctx context.Context, wait time.Duration) (bool, error) {
+	query := url.Values{}
+	query.Set("client_id", c.opts.ClientID)
+	query.Set("cursor", strconv.FormatInt(c.Cursor(), 10))
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+
+	var resp syncResponse
+	if err := c.do(ctx, http.MethodGet, "/config_center/configs", query, nil, &resp); err != nil {
+		return false, fmt.Errorf("sync: %w", err)
+	}
+	if resp.Resync {
+		c.mu.Lock()
+		c.resync = true
+		c.mu.Unlock()
+		c.logger.Warn("cursor is behind purged deletions, resync", "cursor", c.Cursor())
+		return false, nil
+	}
+
+	changed := c.apply(resp.Configs, resp.Cursor)
+	if changed && c.opts.SnapshotPath != "" {
+		if err := c.SaveSnapshot(); err != nil {
+			c.logger.Warn("save snapshot failed", "path", c.opts.SnapshotPath, "error", err)
+		}
+	}
+
+	// 上次确认失败时没有新变更也要重新确认
+	c.mu.RLock()
+	cursor, acked := c.cursor, c.ackedCursor
+	c.mu.RUnlock()
+	if acked < cursor {
+		if err := c.ack(ctx, cursor); err != nil {
+			return changed, fmt.Errorf("ack: %w", err)
+		}
+	}
+
+	return changed, nil
+}
+
+type snapshotResponse struct {
+	Configs []*Config `json:"configs"`
+	Cursor  int64     `json:"cursor"`
+	Hash    string    `json:"hash"`
+}
+
+// Bootstrap 拉取服务端全量快照并校验后替换本地缓存, 之后从快照的游标开始
+// 增量同步
+func (c *Client) Bootstrap(ctx context.Context) error {
+	query := url.Values{}
+	query.Set("client_id", c.opts.ClientID)
+
+	var resp snapshotResponse
+	if err := c.do(ctx, http.MethodGet, "/config_center/snapshot", query, nil, &resp); err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+
+	if hash := HashConfigs(resp.Configs); hash != resp.Hash {
+		return fmt.Errorf("snapshot: hash mismatch, got %s want %s", hash, resp.Hash)
+	}
+
+	c.replace(resp.Configs, resp.Cursor)
+
+	if c.opts.SnapshotPath != "" {
+		if err := c.SaveSnapshot(); err != nil {
+			c.logger.Warn("save snapshot failed", "path", c.opts.SnapshotPath, "error", err)
+		}
+	}
+
+	if err := c.ack(ctx, resp.Cursor); err != nil {
+		return fmt.Errorf("ack: %w", err)
+	}
+
+	return nil
+}
+
+// replace 用全量配置替换缓存, 对新增、变化和消失的配置执行回调
+func (c *Client) replace(configs []*Config, cursor int64) {
+	c.mu.Lock()
+	old := c.configs
+	c.configs = make(map[string]*Config, len(configs))
+	changes := make([]Change, 0)
+	for _, config := range configs {
+		key := configKey(config.Scope, config.Name)
+		c.configs[key] = config
+		if prev, ok := old[key]; !ok || prev.Version != config.Version || prev.Seq != config.Seq {
+			changes = append(changes, Change{Old: prev, New: config})
+		}
+		delete(old, key)
+	}
+	for _, prev := range old {
+		deleted := *prev
+		deleted.Deleted = true
+		changes = append(changes, Change{Old: prev, New: &deleted})
+	}
+	c.cursor = cursor
+	c.resync = false
+	callbacks := c.callbacks
+	c.mu.Unlock()
+
+	for _, change := range changes {
+		for _, callback := range callbacks {
+			callback(change)
+		}
+	}
+}
+
+// ack 确认已应用到 cursor, 同时上报缓存的校验值供服务端检测配置漂移
+func (c *Client) ack(ctx context.Context, cursor int64) error {
+	c.mu.RLock()
+	configs := make([]*Config, 0, len(c.configs))
+	for _, config := range c.configs {
+		configs = append(configs, config)
+	}
+	c.mu.RUnlock()
+
+	req := map[string]interface{}{
+		"client_id":    c.opts.ClientID,
+		"cursor":       cursor,
+		"hash":         HashConfigs(configs),
+		"scope_hashes": HashConfigsByScope(configs),
+	}
+	if err := c.do(ctx, http.MethodPost, "/config_center/ack", nil, req, nil); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.ackedCursor = cursor
+	c.mu.Unlock()
+	return nil
+}
+
+// apply 把变更写入缓存并执行回调, 返回游标是否前进
+func (c *Client) apply(configs []*Config, cursor int64) bool {
+	c.mu.Lock()
+	if cursor <= c.cursor && len(configs) == 0 {
+		c.mu.Unlock()
+		return false
+	}
+
+	changes := make([]Change, 0, len(configs))
+	for _, config := range configs {
+		key := configKey(config.Scope, config.Name)
+		old := c.configs[key]
+		if config.Deleted {
+			if old == nil {
+				continue
+			}
+			delete(c.configs, key)
+		} else {
+			c.configs[key] = config
+		}
+		changes = append(changes, Change{Old: old, New: config})
+	}
+	if cursor > c.cursor {
+		c.cursor = cursor
+	}
+	callbacks := c.callbacks
+	c.mu.Unlock()
+
+	for _, change := range changes {
+		for _, callback := range callbacks {
+			callback(change)
+		}
+	}
+
+	return true
+}
+
+func (c *Client) syncLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	backoff := time.Second
+	for ctx.Err() == nil {
+		c.mu.RLock()
+		registered := c.registered
+		c.mu.RUnlock()
+
+		var err error
+		if !registered {
+			err = c.Register(ctx)
+		}
+		if err == nil && c.needResync() {
+			err = c.Bootstrap(ctx)
+		}
+		if err == nil {
+			start := time.Now()
+			var changed bool
+			changed, err = c.sync(ctx, c.opts.Wait)
+			// 服务端不支持长轮询时没有变更也会立即返回, 稍作等待避免空转
+			if err == nil && !changed && time.Since(start) < c.opts.Wait/2 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+		}
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		// 注册是幂等的, 出错后重新注册以应对服务端丢失注册信息
+		c.mu.Lock()
+		c.registered = false
+		c.mu.Unlock()
+		c.logger.Warn("config sync failed", "error", err, "retry_after", backoff.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (c *Client) heartbeatLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.opts.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Heartbeat(ctx); err != nil && ctx.Err() == nil {
+				c.logger.Warn("config heartbeat failed", "error", err)
+			}
+		}
+	}
+}
+
+type response struct {
+	Code    int             `json:"code"`
+	Data    json.RawMessage `json:"data"`
+	Message string          `json:"message"`
+}
+
+// do 发送请求并解析 {code, data, message} 格式的响应
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u := c.opts.Addr + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = strings.NewReader(string(b))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return err
+	}
+	if r.Code != 0 {
+		return fmt.Errorf("code %d: %s", r.Code, r.Message)
+	}
+
+	if out != nil {
+		return json.Unmarshal(r.Data, out)
+	}
+	return nil
+}
+
+func configKey(scope, name string) string {
+	return scope + "/" + name
+}
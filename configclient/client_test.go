@@ -0,0 +1,333 @@
+package configclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"quant_api/models"
+)
+
+// fakeServer 模拟 /config_center/* 接口, configs 按 seq 升序
+type fakeServer struct {
+	mu        sync.Mutex
+	configs   []*models.Config
+	acked     int64
+	ackedHash string
+	// failAcks 接下来拒绝的 ack 次数
+	failAcks int
+	// resync 注册时要求客户端重新拉取快照
+	resync bool
+	seq    int64
+	// purgedSeq 已清理的墓碑中最大的序号
+	purgedSeq int64
+}
+
+func (f *fakeServer) add(config *models.Config) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	config.Seq = f.seq
+	f.configs = append(f.configs, config)
+}
+
+// purge 清理已删除配置的全部记录
+func (f *fakeServer) purge() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deleted := make(map[string]bool)
+	for _, config := range f.configs {
+		deleted[configKey(config.Scope, config.Name)] = config.Deleted
+	}
+	configs := make([]*models.Config, 0, len(f.configs))
+	for _, config := range f.configs {
+		if deleted[configKey(config.Scope, config.Name)] {
+			f.purgedSeq = max(f.purgedSeq, config.Seq)
+			continue
+		}
+		configs = append(configs, config)
+	}
+	f.configs = configs
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data := map[string]interface{}{}
+	switch r.URL.Path {
+	case "/config_center/register":
+		data["client"] = map[string]interface{}{"resync": f.resync}
+	case "/config_center/heartbeat":
+	case "/config_center/configs":
+		cursor, _ := strconv.ParseInt(r.URL.Query().Get("cursor"), 10, 64)
+		if cursor < f.purgedSeq {
+			data["configs"] = []*models.Config{}
+			data["cursor"] = cursor
+			data["resync"] = true
+			break
+		}
+		configs := make([]*models.Config, 0)
+		for _, config := range f.configs {
+			if config.Seq > cursor {
+				configs = append(configs, config)
+				cursor = config.Seq
+			}
+		}
+		data["configs"] = configs
+		data["cursor"] = cursor
+	case "/config_center/snapshot":
+		configs := make([]*models.Config, 0)
+		live := make(map[string]*models.Config)
+		cursor := f.seq
+		for _, config := range f.configs {
+			live[configKey(config.Scope, config.Name)] = config
+		}
+		for _, config := range live {
+			if !config.Deleted {
+				configs = append(configs, config)
+			}
+		}
+		data["configs"] = configs
+		data["cursor"] = cursor
+		data["hash"] = models.HashConfigs(configs)
+	case "/config_center/ack":
+		if f.failAcks > 0 {
+			f.failAcks--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var ack struct {
+			Cursor int64  `json:"cursor"`
+			Hash   string `json:"hash"`
+		}
+		json.NewDecoder(r.Body).Decode(&ack)
+		f.acked = ack.Cursor
+		f.ackedHash = ack.Hash
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"code": 0, "data": data, "message": "ok"})
+}
+
+func TestClientSync(t *testing.T) {
+	server := &fakeServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	server.add(&models.Config{Scope: ScopeStock, Name: "600000", Value: models.JsonObject{"prod_status": true, "up_limit": 10.5}})
+	server.add(&models.Config{Scope: ScopeGlobal, Name: "default", Value: models.JsonObject{"broker": "xt"}})
+
+	snapshotPath := filepath.Join(t.TempDir(), "configs.json")
+	client, err := New(Options{Addr: ts.URL, ClientID: "test", SnapshotPath: snapshotPath})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	var changes []Change
+	client.OnChange(func(change Change) {
+		changes = append(changes, change)
+	})
+
+	ctx := context.Background()
+	if err := client.Sync(ctx, 0); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	if v, ok := client.ProdStatus("600000"); !ok || !v {
+		t.Errorf("ProdStatus() = %v, %v, want true, true", v, ok)
+	}
+	if v, ok := client.UpLimit("600000"); !ok || v != 10.5 {
+		t.Errorf("UpLimit() = %v, %v, want 10.5, true", v, ok)
+	}
+	if v, ok := client.Broker("default"); !ok || v != "xt" {
+		t.Errorf("Broker() = %v, %v, want xt, true", v, ok)
+	}
+	if len(changes) != 2 {
+		t.Errorf("got %d changes, want 2", len(changes))
+	}
+	if server.acked != 2 {
+		t.Errorf("acked cursor = %d, want 2", server.acked)
+	}
+	if want := models.HashConfigs(server.configs); server.ackedHash != want {
+		t.Errorf("acked hash = %s, want %s", server.ackedHash, want)
+	}
+
+	server.add(&models.Config{Scope: ScopeStock, Name: "600000", Deleted: true})
+	if err := client.Sync(ctx, 0); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if _, ok := client.Get(ScopeStock, "600000"); ok {
+		t.Errorf("deleted config is still cached")
+	}
+	if client.Cursor() != 3 {
+		t.Errorf("Cursor() = %d, want 3", client.Cursor())
+	}
+
+	// 服务端不可用时从快照恢复
+	ts.Close()
+	restored, err := New(Options{Addr: ts.URL, ClientID: "test", SnapshotPath: snapshotPath})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := restored.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer restored.Close()
+
+	if v, ok := restored.Broker("default"); !ok || v != "xt" {
+		t.Errorf("restored Broker() = %v, %v, want xt, true", v, ok)
+	}
+	if restored.Cursor() != 3 {
+		t.Errorf("restored Cursor() = %d, want 3", restored.Cursor())
+	}
+}
+
+func TestClientBootstrap(t *testing.T) {
+	server := &fakeServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	server.add(&models.Config{Scope: ScopeStock, Name: "600000", Value: models.JsonObject{"low_limit": 9.5}})
+	server.add(&models.Config{Scope: ScopeStock, Name: "600001", Value: models.JsonObject{"pre_status": true}})
+	server.add(&models.Config{Scope: ScopeStock, Name: "600001", Deleted: true})
+
+	client, err := New(Options{Addr: ts.URL, ClientID: "test"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer client.Close()
+
+	if v, ok := client.LowLimit("600000"); !ok || v != 9.5 {
+		t.Errorf("LowLimit() = %v, %v, want 9.5, true", v, ok)
+	}
+	if _, ok := client.PreStatus("600001"); ok {
+		t.Errorf("deleted config is in snapshot")
+	}
+	if client.Cursor() != 3 {
+		t.Errorf("Cursor() = %d, want 3", client.Cursor())
+	}
+}
+
+func TestClientReack(t *testing.T) {
+	server := &fakeServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	server.add(&models.Config{Scope: ScopeStock, Name: "600000", Value: models.JsonObject{"up_limit": 10.5}})
+
+	client, err := New(Options{Addr: ts.URL, ClientID: "test"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx := context.Background()
+	server.failAcks = 1
+	if err := client.Sync(ctx, 0); err == nil {
+		t.Fatalf("Sync() error = nil, want ack error")
+	}
+	if client.Cursor() != 1 || server.acked != 0 {
+		t.Fatalf("cursor = %d, acked = %d, want 1, 0", client.Cursor(), server.acked)
+	}
+
+	// 没有新变更时补发确认
+	if err := client.Sync(ctx, 0); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if server.acked != 1 {
+		t.Errorf("acked cursor = %d, want 1", server.acked)
+	}
+	if want := models.HashConfigs(server.configs); server.ackedHash != want {
+		t.Errorf("acked hash = %s, want %s", server.ackedHash, want)
+	}
+}
+
+func TestClientResync(t *testing.T) {
+	server := &fakeServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	server.add(&models.Config{Scope: ScopeStock, Name: "600000", Value: models.JsonObject{"up_limit": 10.5}})
+	server.add(&models.Config{Scope: ScopeGlobal, Name: "default", Value: models.JsonObject{"broker": "xt"}})
+
+	// 快照中只有 stock 配置, 游标已在 global 配置之后
+	snapshotPath := filepath.Join(t.TempDir(), "configs.json")
+	client, err := New(Options{Addr: ts.URL, ClientID: "test", SnapshotPath: snapshotPath})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	client.apply([]*Config{{Scope: ScopeStock, Name: "600000", Value: map[string]interface{}{"up_limit": 10.5}, Seq: 1}}, 2)
+	if err := client.SaveSnapshot(); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	server.resync = true
+	restarted, err := New(Options{Addr: ts.URL, ClientID: "test", SnapshotPath: snapshotPath, Scopes: []string{ScopeStock, ScopeGlobal}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := restarted.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer restarted.Close()
+
+	if v, ok := restarted.Broker("default"); !ok || v != "xt" {
+		t.Errorf("Broker() = %v, %v, want xt, true", v, ok)
+	}
+	if restarted.needResync() {
+		t.Errorf("resync is still pending after Start")
+	}
+}
+
+func TestClientPurgedDeletes(t *testing.T) {
+	server := &fakeServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	server.add(&models.Config{Scope: ScopeStock, Name: "600000", Value: models.JsonObject{"up_limit": 10.5}})
+	server.add(&models.Config{Scope: ScopeStock, Name: "600001", Value: models.JsonObject{"up_limit": 9.5}})
+
+	snapshotPath := filepath.Join(t.TempDir(), "configs.json")
+	client, err := New(Options{Addr: ts.URL, ClientID: "test", SnapshotPath: snapshotPath})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := client.Sync(context.Background(), 0); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// 客户端离线期间 600000 被删除, 墓碑随后被清理
+	server.add(&models.Config{Scope: ScopeStock, Name: "600000", Deleted: true})
+	server.purge()
+
+	restarted, err := New(Options{Addr: ts.URL, ClientID: "test", SnapshotPath: snapshotPath})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := restarted.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer restarted.Close()
+
+	if _, ok := restarted.UpLimit("600000"); ok {
+		t.Errorf("deleted config 600000 is still cached")
+	}
+	if v, ok := restarted.UpLimit("600001"); !ok || v != 9.5 {
+		t.Errorf("UpLimit(600001) = %v, %v, want 9.5, true", v, ok)
+	}
+	if got := restarted.Cursor(); got != 3 {
+		t.Errorf("Cursor() = %d, want 3", got)
+	}
+}
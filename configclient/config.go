@@ -0,0 +1,82 @@
+package configclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+)
+
+// Config 配置中心下发的配置, json 格式与服务端 /config_center/* 接口一致.
+// 客户端包不依赖 models, 避免引入数据库驱动.
+type Config struct {
+	ID           int                    `json:"id"`
+	Scope        string                 `json:"scope"`
+	Name         string                 `json:"name"`
+	Value        map[string]interface{} `json:"value"`
+	ChangedValue map[string]interface{} `json:"changed_value"`
+	CreateTime   string                 `json:"create_time"`
+	UpdateTime   string                 `json:"update_time"`
+	UpdateUser   string                 `json:"update_user"`
+	Version      int                    `json:"version"`
+	DeletedAt    *string                `json:"deleted_at,omitempty"`
+	Seq          int64                  `json:"seq"`
+	Deleted      bool                   `json:"deleted"`
+}
+
+// Subscription 订阅 scope 下的配置, Names 与 Prefix 都为空时订阅整个 scope
+type Subscription struct {
+	Scope  string   `json:"scope"`
+	Names  []string `json:"names,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+}
+
+type hashEntry struct {
+	Scope string                 `json:"scope"`
+	Name  string                 `json:"name"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// HashConfigs returns the sha256 of the scope, name and value of configs,
+// independent of their order. It must stay the same as models.HashConfigs on
+// the server.
+func HashConfigs(configs []*Config) string {
+	entries := make([]hashEntry, 0, len(configs))
+	for _, config := range configs {
+		if config.Deleted {
+			continue
+		}
+		entries = append(entries, hashEntry{Scope: config.Scope, Name: config.Name, Value: config.Value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Scope != entries[j].Scope {
+			return entries[i].Scope < entries[j].Scope
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	h := sha256.New()
+	for _, entry := range entries {
+		b, _ := json.Marshal(entry)
+		h.Write(b)
+		h.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashConfigsByScope returns HashConfigs of the configs of every scope
+func HashConfigsByScope(configs []*Config) map[string]string {
+	scopes := make(map[string][]*Config)
+	for _, config := range configs {
+		if !config.Deleted {
+			scopes[config.Scope] = append(scopes[config.Scope], config)
+		}
+	}
+
+	hashes := make(map[string]string, len(scopes))
+	for scope, scopeConfigs := range scopes {
+		hashes[scope] = HashConfigs(scopeConfigs)
+	}
+	return hashes
+}
@@ -0,0 +1,67 @@
+package configclient
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+type snapshot struct {
+	Cursor  int64     `json:"cursor"`
+	Configs []*Config `json:"configs"`
+}
+
+// SaveSnapshot 把缓存写入 SnapshotPath, 先写临时文件再改名, 避免进程退出时
+// 留下不完整的快照
+func (c *Client) SaveSnapshot() error {
+	c.mu.RLock()
+	snap := snapshot{Cursor: c.cursor, Configs: make([]*Config, 0, len(c.configs))}
+	for _, config := range c.configs {
+		snap.Configs = append(snap.Configs, config)
+	}
+	data, err := json.Marshal(snap)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.opts.SnapshotPath), filepath.Base(c.opts.SnapshotPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.opts.SnapshotPath)
+}
+
+// LoadSnapshot 用 SnapshotPath 中的快照替换缓存
+func (c *Client) LoadSnapshot() error {
+	data, err := os.ReadFile(c.opts.SnapshotPath)
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.configs = make(map[string]*Config, len(snap.Configs))
+	for _, config := range snap.Configs {
+		c.configs[configKey(config.Scope, config.Name)] = config
+	}
+	c.cursor = snap.Cursor
+
+	return nil
+}
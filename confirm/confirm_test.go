@@ -0,0 +1,59 @@
+package confirm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner([]byte("secret"), time.Minute)
+	now := time.Unix(1000, 0)
+	signer.now = func() time.Time { return now }
+
+	token, claims, err := signer.Issue("trader", "600000")
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if claims.Expire != now.Add(time.Minute).Unix() || claims.Nonce == "" {
+		t.Fatalf("Issue() claims = %+v", claims)
+	}
+
+	got, err := signer.Verify(token, "trader", "600000")
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if got.Nonce != claims.Nonce {
+		t.Errorf("Verify() nonce = %s, want %s", got.Nonce, claims.Nonce)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		user    string
+		subject string
+		want    error
+	}{
+		{"other user", token, "admin", "600000", ErrTokenBinding},
+		{"other stock", token, "trader", "600001", ErrTokenBinding},
+		{"tampered", token[:len(token)-2] + "xx", "trader", "600000", ErrInvalidToken},
+		{"malformed", "token", "trader", "600000", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := signer.Verify(tt.token, tt.user, tt.subject); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	other := NewSigner([]byte("other"), time.Minute)
+	if _, err := other.Verify(token, "trader", "600000"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() with other secret error = %v, want ErrInvalidToken", err)
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if _, err := signer.Verify(token, "trader", "600000"); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("Verify() expired error = %v, want ErrExpiredToken", err)
+	}
+}
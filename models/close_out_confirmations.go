@@ -0,0 +1,73 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"quant_api/database"
+	"quant_api/utils"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// close_out_confirmations 记录已使用的平仓确认令牌, 保证每个令牌只能使用一次
+
+/*
+CREATE TABLE `close_out_confirmations` (
+  `nonce` varchar(64) NOT NULL,
+  `requester` varchar(50) NOT NULL DEFAULT 'admin',
+  `subject` varchar(1000) NOT NULL DEFAULT '',
+  `expire_time` bigint NOT NULL DEFAULT 0,
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`nonce`),
+  KEY (`expire_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+*/
+
+var ErrConfirmationUsed = errors.New("confirmation token already used")
+
+// UseCloseOutConfirmation marks the token nonce as used, returns
+// ErrConfirmationUsed when it has been used before
+func UseCloseOutConfirmation(nonce, requester, subject string, expire int64) error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO close_out_confirmations(nonce, requester, subject, expire_time) VALUES(?, ?, ?, ?)",
+		nonce, requester, utils.Truncate(subject, 1000), expire)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return ErrConfirmationUsed
+	}
+
+	return err
+}
+
+// ReleaseCloseOutConfirmation forgets the used token nonce, so a token spent on
+// a close out that was never recorded can be used again
+func ReleaseCloseOutConfirmation(nonce string) error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM close_out_confirmations WHERE nonce = ?", nonce)
+	return err
+}
+
+// PurgeCloseOutConfirmations removes the expired tokens, they can not be used
+// again anyway
+func PurgeCloseOutConfirmations() (int64, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.Exec("DELETE FROM close_out_confirmations WHERE expire_time < ?", time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
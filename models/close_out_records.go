@@ -0,0 +1,339 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"quant_api/database"
+	"quant_api/utils"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+/*
+CREATE TABLE `close_out_records` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `request_id` varchar(50) NOT NULL,
+  `idempotency_key` varchar(100) DEFAULT NULL,
+  `stock_code` varchar(50) NOT NULL,
+  `requester` varchar(50) NOT NULL DEFAULT 'admin',
+  `status` varchar(20) NOT NULL DEFAULT 'pending',
+  `async` tinyint(1) NOT NULL DEFAULT 0,
+  `endpoint` varchar(100) NOT NULL DEFAULT '',
+  `reconcile_attempts` int NOT NULL DEFAULT 0,
+  `price` double NOT NULL DEFAULT 0,
+  `canceled_enter_tasks` json,
+  `canceled_exit_tasks` json,
+  `close_out_qty` json,
+  `total_qty` int NOT NULL DEFAULT 0,
+  `message` varchar(500) NOT NULL DEFAULT '',
+  `error` varchar(500) NOT NULL DEFAULT '',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY (`idempotency_key`),
+  KEY (`stock_code`, `create_time`),
+  KEY (`create_time`),
+  KEY (`status`, `update_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+*/
+
+// 平仓状态: pending 已记录未发送, sent 已发往 quant_core, unknown 请求可能
+// 已执行但未收到结果, 需要向 quant_core 核对
+const (
+	CloseOutStatusPending   = "pending"
+	CloseOutStatusSent      = "sent"
+	CloseOutStatusSucceeded = "succeeded"
+	CloseOutStatusFailed    = "failed"
+	CloseOutStatusUnknown   = "unknown"
+)
+
+var (
+	ErrCloseOutRecordNotFound = errors.New("close out record not found")
+	ErrDuplicateIdempotency   = errors.New("idempotency key already used")
+	ErrCloseOutExpired        = errors.New("close out record expired before it was sent")
+)
+
+// 超时未执行的平仓记录的错误信息
+const closeOutExpiredError = "平仓任务超时未执行"
+
+// CloseOutRecord 一次平仓请求及 quant_core 返回的结果
+type CloseOutRecord struct {
+	ID                 int64    `db:"id" json:"id"`
+	RequestID          string   `db:"request_id" json:"request_id"`
+	IdempotencyKey     *string  `db:"idempotency_key" json:"idempotency_key,omitempty"`
+	StockCode          string   `db:"stock_code" json:"stock_code"`
+	Requester          string   `db:"requester" json:"requester"`
+	Status             string   `db:"status" json:"status"`
+	Async              bool     `db:"async" json:"async"`
+	Endpoint           string   `db:"endpoint" json:"endpoint"`
+	ReconcileAttempts  int      `db:"reconcile_attempts" json:"reconcile_attempts"`
+	Price              float64  `db:"price" json:"price"`
+	CanceledEnterTasks IntList  `db:"canceled_enter_tasks" json:"canceled_enter_tasks"`
+	CanceledExitTasks  IntList  `db:"canceled_exit_tasks" json:"canceled_exit_tasks"`
+	CloseOutQty        QtyByKey `db:"close_out_qty" json:"close_out_qty"`
+	TotalQty           int      `db:"total_qty" json:"total_qty"`
+	Message            string   `db:"message" json:"message"`
+	Error              string   `db:"error" json:"error"`
+	CreateTime         string   `db:"create_time" json:"create_time"`
+	UpdateTime         string   `db:"update_time" json:"update_time"`
+} // @name CloseOutRecord
+
+type IntList []int
+
+func (l *IntList) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case nil:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("Unsupported type: %T", v))
+	}
+}
+
+// QtyByKey 每个任务的平仓数量
+type QtyByKey map[int]int
+
+func (m *QtyByKey) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case nil:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("Unsupported type: %T", v))
+	}
+}
+
+// Create inserts the record as pending, returns ErrDuplicateIdempotency when
+// the idempotency key has been used.
+func (r *CloseOutRecord) Create() error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	r.Status = CloseOutStatusPending
+	res, err := db.Exec("INSERT INTO close_out_records(request_id, idempotency_key, stock_code, requester, status, async) VALUES(?, ?, ?, ?, ?, ?)",
+		r.RequestID, r.IdempotencyKey, r.StockCode, r.Requester, r.Status, r.Async)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+		return ErrDuplicateIdempotency
+	}
+	if err != nil {
+		return err
+	}
+
+	r.ID, err = res.LastInsertId()
+	return err
+}
+
+// Claim marks the pending record as sent, returns false when another worker
+// has taken it. A record created more than maxAge ago is not sent any more, it
+// is marked as failed and ErrCloseOutExpired is returned.
+func (r *CloseOutRecord) Claim(maxAge time.Duration) (bool, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := db.Exec("UPDATE close_out_records SET status = ? WHERE id = ? AND status = ? AND create_time >= NOW() - INTERVAL ? SECOND",
+		CloseOutStatusSent, r.ID, CloseOutStatusPending, int64(maxAge.Seconds()))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected > 0 {
+		r.Status = CloseOutStatusSent
+		return true, nil
+	}
+
+	res, err = db.Exec("UPDATE close_out_records SET status = ?, error = ? WHERE id = ? AND status = ?",
+		CloseOutStatusFailed, closeOutExpiredError, r.ID, CloseOutStatusPending)
+	if err != nil {
+		return false, err
+	}
+	if affected, err = res.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	r.Status = CloseOutStatusFailed
+	r.Error = closeOutExpiredError
+	return false, ErrCloseOutExpired
+}
+
+// Save the result of the close out
+func (r *CloseOutRecord) Save() error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	enterStr, _ := json.Marshal(r.CanceledEnterTasks)
+	exitStr, _ := json.Marshal(r.CanceledExitTasks)
+	qtyStr, _ := json.Marshal(r.CloseOutQty)
+
+	_, err = db.Exec("UPDATE close_out_records SET status = ?, endpoint = ?, reconcile_attempts = ?, price = ?, canceled_enter_tasks = ?, canceled_exit_tasks = ?, close_out_qty = ?, total_qty = ?, message = ?, error = ? WHERE id = ?",
+		r.Status, r.Endpoint, r.ReconcileAttempts, r.Price, enterStr, exitStr, qtyStr, r.TotalQty, utils.Truncate(r.Message, 500), utils.Truncate(r.Error, 500), r.ID)
+
+	return err
+}
+
+func GetCloseOutRecord(id int64) (*CloseOutRecord, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var record CloseOutRecord
+	err = db.Get(&record, "SELECT * FROM close_out_records WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCloseOutRecordNotFound
+	}
+
+	return &record, err
+}
+
+// GetPendingCloseOutJobs returns the asynchronous jobs waiting for a worker
+func GetPendingCloseOutJobs(limit int) ([]*CloseOutRecord, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*CloseOutRecord, 0)
+	err = db.Select(&records, "SELECT * FROM close_out_records WHERE status = ? AND async = 1 ORDER BY id LIMIT ?", CloseOutStatusPending, limit)
+
+	return records, err
+}
+
+// GetUnknownCloseOutRecords returns the records to reconcile, oldest first
+func GetUnknownCloseOutRecords(maxAttempts, limit int) ([]*CloseOutRecord, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*CloseOutRecord, 0)
+	err = db.Select(&records, "SELECT * FROM close_out_records WHERE status = ? AND reconcile_attempts < ? ORDER BY id LIMIT ?", CloseOutStatusUnknown, maxAttempts, limit)
+
+	return records, err
+}
+
+// MarkStaleCloseOutRecords marks the records sent more than olderThan ago as
+// unknown, the process sending them has probably exited
+func MarkStaleCloseOutRecords(olderThan time.Duration) (int64, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.Exec("UPDATE close_out_records SET status = ? WHERE status = ? AND update_time < NOW() - INTERVAL ? SECOND",
+		CloseOutStatusUnknown, CloseOutStatusSent, int64(olderThan.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// MarkStalePendingCloseOutRecords marks the synchronous records still pending
+// after olderThan as failed. They are only pending between Create and Claim, so
+// the process has exited before sending them to quant_core.
+func MarkStalePendingCloseOutRecords(olderThan time.Duration) (int64, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.Exec("UPDATE close_out_records SET status = ?, error = ? WHERE status = ? AND async = 0 AND update_time < NOW() - INTERVAL ? SECOND",
+		CloseOutStatusFailed, "平仓请求未发送", CloseOutStatusPending, int64(olderThan.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// MarkExpiredCloseOutJobs marks the asynchronous jobs still pending more than
+// maxAge after they were created as failed, they are not sent any more
+func MarkExpiredCloseOutJobs(maxAge time.Duration) (int64, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.Exec("UPDATE close_out_records SET status = ?, error = ? WHERE status = ? AND async = 1 AND create_time < NOW() - INTERVAL ? SECOND",
+		CloseOutStatusFailed, closeOutExpiredError, CloseOutStatusPending, int64(maxAge.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func GetCloseOutRecordByKey(key string) (*CloseOutRecord, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var record CloseOutRecord
+	err = db.Get(&record, "SELECT * FROM close_out_records WHERE idempotency_key = ?", key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCloseOutRecordNotFound
+	}
+
+	return &record, err
+}
+
+// CloseOutFilter 查询条件, 为空的条件不过滤, 时间为 "2006-01-02 15:04:05"
+// 格式, 区间左闭右开
+type CloseOutFilter struct {
+	StockCode string
+	Since     string
+	Until     string
+	Limit     int
+}
+
+// GetCloseOutRecords returns the matching records, newest first
+func GetCloseOutRecords(filter CloseOutFilter) ([]*CloseOutRecord, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT * FROM close_out_records WHERE 1 = 1"
+	args := make([]interface{}, 0, 4)
+	if filter.StockCode != "" {
+		query += " AND stock_code = ?"
+		args = append(args, filter.StockCode)
+	}
+	if filter.Since != "" {
+		query += " AND create_time >= ?"
+		args = append(args, filter.Since)
+	}
+	if filter.Until != "" {
+		query += " AND create_time < ?"
+		args = append(args, filter.Until)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	records := make([]*CloseOutRecord, 0)
+	err = db.Select(&records, query, args...)
+
+	return records, err
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"quant_api/database"
+)
+
+/*
+CREATE TABLE `config_clients` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `client_id` varchar(100) NOT NULL,
+  `name` varchar(100) NOT NULL DEFAULT '',
+  `host` varchar(100) NOT NULL DEFAULT '',
+  `version` varchar(50) NOT NULL DEFAULT '',
+  `environment` varchar(50) NOT NULL DEFAULT '',
+  `subscriptions` json,
+  `last_seen` bigint NOT NULL DEFAULT 0,
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY (`client_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+*/
+
+var ErrClientNotRegistered = errors.New("client not registered")
+
+// ConfigClient 为注册到配置中心的客户端
+type ConfigClient struct {
+	ID            int           `db:"id" json:"id"`
+	ClientID      string        `db:"client_id" json:"client_id"`
+	Name          string        `db:"name" json:"name"`
+	Host          string        `db:"host" json:"host"`
+	Version       string        `db:"version" json:"version"`
+	Environment   string        `db:"environment" json:"environment"`
+	Subscriptions Subscriptions `db:"subscriptions" json:"subscriptions"`
+	LastSeen      int64         `db:"last_seen" json:"last_seen"`
+	CreateTime    string        `db:"create_time" json:"create_time"`
+	UpdateTime    string        `db:"update_time" json:"update_time"`
+	// Resync 注册时订阅范围变化, 客户端需要重新拉取快照: 扩大时游标之前新订阅
+	// 的配置不会再增量下发, 缩小时缓存中不再订阅的配置不会被删除
+	Resync bool `db:"-" json:"resync"`
+} // @name ConfigClient
+
+// Register creates or updates the client and makes sure it has a sync status.
+// Resync is set when the subscriptions are changed, and the sync position is
+// reset when they are widened.
+func (c *ConfigClient) Register() error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if c.Subscriptions == nil {
+		c.Subscriptions = Subscriptions{}
+	}
+
+	var old Subscriptions
+	err = tx.Get(&old, "SELECT subscriptions FROM config_clients WHERE client_id = ? FOR UPDATE", c.ClientID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	widened := err == nil && !old.Covers(c.Subscriptions)
+	resync := widened || err == nil && !c.Subscriptions.Covers(old)
+	subscriptionsStr, _ := json.Marshal(c.Subscriptions)
+	c.LastSeen = time.Now().Unix()
+
+	_, err = tx.Exec("INSERT INTO config_clients(client_id, name, host, version, environment, subscriptions, last_seen) VALUES(?, ?, ?, ?, ?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE name = VALUES(name), host = VALUES(host), version = VALUES(version), environment = VALUES(environment), subscriptions = VALUES(subscriptions), last_seen = VALUES(last_seen)",
+		c.ClientID, c.Name, c.Host, c.Version, c.Environment, subscriptionsStr, c.LastSeen)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT IGNORE INTO sync_status(client_id) VALUES(?)", c.ClientID)
+	if err != nil {
+		return err
+	}
+
+	// 同步位置回到起点, 直到客户端拉取快照后重新确认
+	if widened {
+		_, err = tx.Exec("UPDATE sync_status SET delivered_cursor = 0, applied_cursor = 0 WHERE client_id = ?", c.ClientID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Get(c, "SELECT * FROM config_clients WHERE client_id = ?", c.ClientID); err != nil {
+		return err
+	}
+	c.Resync = resync
+
+	return tx.Commit()
+}
+
+// Heartbeat refreshes last_seen of the client
+func Heartbeat(clientID string) error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Exec("UPDATE config_clients SET last_seen = ? WHERE client_id = ?", time.Now().Unix(), clientID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrClientNotRegistered
+	}
+
+	return nil
+}
+
+func GetConfigClient(clientID string) (*ConfigClient, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var client ConfigClient
+	err = db.Get(&client, "SELECT * FROM config_clients WHERE client_id = ?", clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrClientNotRegistered
+	}
+
+	return &client, err
+}
+
+func GetConfigClients() ([]*ConfigClient, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]*ConfigClient, 0)
+	err = db.Select(&clients, "SELECT * FROM config_clients ORDER BY client_id")
+
+	return clients, err
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"quant_api/database"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// config_outbox 与配置写入在同一事务中记录变更事件, 由 outbox 包的后台任务
+// 按 id 顺序投递给各个下游, 每个下游在 config_outbox_sinks 中记录各自投递到
+// 的位置与重试状态, 所有下游都投递后事件标记为 done. 进程在写入与投递之间
+// 退出时事件不会丢失, 下游需按 seq 去重.
+
+/*
+CREATE TABLE `config_outbox` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `seq` bigint NOT NULL DEFAULT 0,
+  `event` json,
+  `status` varchar(20) NOT NULL DEFAULT 'pending',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY (`status`, `id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+
+CREATE TABLE `config_outbox_sinks` (
+  `sink` varchar(50) NOT NULL,
+  `delivered_id` bigint NOT NULL DEFAULT 0,
+  `attempts` int NOT NULL DEFAULT 0,
+  `next_attempt` bigint NOT NULL DEFAULT 0,
+  `last_error` varchar(500) NOT NULL DEFAULT '',
+  `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`sink`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+*/
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusDone    = "done"
+)
+
+// OutboxMessage 一条配置变更事件, 所有下游都投递后 Status 为 done
+type OutboxMessage struct {
+	ID         int64       `db:"id" json:"id"`
+	Seq        int64       `db:"seq" json:"seq"`
+	Event      ConfigEvent `db:"event" json:"event"`
+	Status     string      `db:"status" json:"status"`
+	CreateTime string      `db:"create_time" json:"create_time"`
+	UpdateTime string      `db:"update_time" json:"update_time"`
+} // @name OutboxMessage
+
+// OutboxSink 一个下游的投递位置, DeliveredID 及之前的事件已投递, 投递失败
+// 时在 NextAttempt 之后从 DeliveredID 之后的事件重试
+type OutboxSink struct {
+	Sink        string `db:"sink" json:"sink"`
+	DeliveredID int64  `db:"delivered_id" json:"delivered_id"`
+	Attempts    int    `db:"attempts" json:"attempts"`
+	NextAttempt int64  `db:"next_attempt" json:"next_attempt"`
+	LastError   string `db:"last_error" json:"last_error"`
+	UpdateTime  string `db:"update_time" json:"update_time"`
+} // @name OutboxSink
+
+func (e *ConfigEvent) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		return json.Unmarshal(v, e)
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	case nil:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("Unsupported type: %T", v))
+	}
+}
+
+// recordChange writes the revision and the outbox event of a change to c, must
+// be called in the same transaction as the write to configs. oldValue is the
+// value before the change, nil for a created config.
+func (c *Config) recordChange(tx *sqlx.Tx, action string, oldValue JsonObject) error {
+	if err := createRevision(tx, c, action); err != nil {
+		return err
+	}
+
+	event := &ConfigEvent{
+		Seq:        c.Seq,
+		Action:     action,
+		Scope:      c.Scope,
+		Name:       c.Name,
+		Value:      c.Value,
+		OldValue:   oldValue,
+		Version:    c.Version,
+		UpdateUser: c.UpdateUser,
+		Time:       time.Now().Format(time.DateTime),
+	}
+	if c.Deleted {
+		event.Value = nil
+	}
+
+	return createOutboxMessage(tx, event)
+}
+
+func createOutboxMessage(tx *sqlx.Tx, event *ConfigEvent) error {
+	eventStr, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO config_outbox(seq, event, status) VALUES(?, ?, ?)", event.Seq, eventStr, OutboxStatusPending)
+
+	return err
+}
+
+// GetOutboxMessagesAfter returns at most limit messages after id ordered by id
+func GetOutboxMessagesAfter(id int64, limit int) ([]*OutboxMessage, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*OutboxMessage, 0)
+	err = db.Select(&messages, "SELECT * FROM config_outbox WHERE id > ? ORDER BY id LIMIT ?", id, limit)
+
+	return messages, err
+}
+
+// MarkOutboxMessagesDone marks the messages up to id as delivered to every sink
+func MarkOutboxMessagesDone(id int64) error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE config_outbox SET status = ? WHERE status = ? AND id <= ?", OutboxStatusDone, OutboxStatusPending, id)
+
+	return err
+}
+
+// GetOutboxSink returns the position of the sink. A new sink starts before
+// the oldest pending message, so it does not replay what was already
+// delivered to every other sink.
+func GetOutboxSink(sink string) (*OutboxSink, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec("INSERT IGNORE INTO config_outbox_sinks(sink, delivered_id) "+
+		"SELECT ?, COALESCE((SELECT MIN(id) - 1 FROM config_outbox WHERE status = ?), (SELECT MAX(id) FROM config_outbox), 0)",
+		sink, OutboxStatusPending)
+	if err != nil {
+		return nil, err
+	}
+
+	var s OutboxSink
+	err = db.Get(&s, "SELECT * FROM config_outbox_sinks WHERE sink = ?", sink)
+
+	return &s, err
+}
+
+// Save the position and retry state of the sink, the position never moves
+// back when several instances dispatch at the same time
+func (s *OutboxSink) Save() error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE config_outbox_sinks SET delivered_id = GREATEST(delivered_id, ?), attempts = ?, next_attempt = ?, last_error = ? WHERE sink = ?",
+		s.DeliveredID, s.Attempts, s.NextAttempt, s.LastError, s.Sink)
+
+	return err
+}
+
+// OutboxLag 尚未投递的变更事件, Oldest 为最早一条的写入时间(unix 秒),
+// 没有积压时为 0
+type OutboxLag struct {
+	Pending   int64 `db:"pending" json:"pending"`
+	OldestSeq int64 `db:"oldest_seq" json:"oldest_seq"`
+	Oldest    int64 `db:"oldest" json:"oldest"`
+}
+
+// GetOutboxLag returns the backlog of pending messages
+func GetOutboxLag() (*OutboxLag, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var lag OutboxLag
+	err = db.Get(&lag, "SELECT COUNT(*) AS pending, COALESCE(MIN(seq), 0) AS oldest_seq, COALESCE(UNIX_TIMESTAMP(MIN(create_time)), 0) AS oldest FROM config_outbox WHERE status = ?",
+		OutboxStatusPending)
+
+	return &lag, err
+}
+
+// PurgeOutboxMessages removes the dispatched messages older than retention
+func PurgeOutboxMessages(retention time.Duration) (int64, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.Exec("DELETE FROM config_outbox WHERE status = ? AND update_time < NOW() - INTERVAL ? SECOND", OutboxStatusDone, int64(retention.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
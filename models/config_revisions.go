@@ -0,0 +1,175 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"quant_api/database"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// config_revisions 同时作为配置的变更日志, seq 为全局单调递增的变更序号
+
+/*
+CREATE TABLE `config_revisions` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `config_id` int NOT NULL DEFAULT 0,
+  `scope` varchar(50) NOT NULL DEFAULT 'stock',
+  `name` varchar(50) NOT NULL DEFAULT 'default_name',
+  `action` varchar(20) NOT NULL DEFAULT 'update',
+  `value` json,
+  `changed_value` json,
+  `update_user` varchar(50) NOT NULL DEFAULT 'admin',
+  `version` int NOT NULL DEFAULT 1,
+  `seq` bigint NOT NULL DEFAULT 0,
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY (`scope`, `name`),
+  KEY (`seq`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+*/
+
+const (
+	RevisionActionCreate   = "create"
+	RevisionActionUpdate   = "update"
+	RevisionActionDelete   = "delete"
+	RevisionActionRollback = "rollback"
+	RevisionActionPromote  = "promote"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// ConfigRevision is a snapshot of a config taken on every write.
+type ConfigRevision struct {
+	ID           int        `db:"id" json:"id"`
+	ConfigID     int        `db:"config_id" json:"config_id"`
+	Scope        string     `db:"scope" json:"scope"`
+	Name         string     `db:"name" json:"name"`
+	Action       string     `db:"action" json:"action"`
+	Value        JsonObject `db:"value" json:"value"`
+	ChangedValue JsonObject `db:"changed_value" json:"changed_value"`
+	UpdateUser   string     `db:"update_user" json:"update_user"`
+	Version      int        `db:"version" json:"version"`
+	Seq          int64      `db:"seq" json:"seq"`
+	CreateTime   string     `db:"create_time" json:"create_time"`
+} // @name ConfigRevision
+
+// ConfigEvent describes a single change of a config
+type ConfigEvent struct {
+	Seq        int64      `json:"seq"`
+	Action     string     `json:"action"`
+	Scope      string     `json:"scope"`
+	Name       string     `json:"name"`
+	Value      JsonObject `json:"value"`
+	OldValue   JsonObject `json:"old_value,omitempty"`
+	Version    int        `json:"version"`
+	UpdateUser string     `json:"update_user"`
+	Time       string     `json:"time"`
+} // @name ConfigEvent
+
+// Event returns the changelog entry as an event. A delete revision keeps the
+// value before the deletion, it is sent as OldValue with a nil Value, the same
+// as the events in config_outbox.
+func (r *ConfigRevision) Event() *ConfigEvent {
+	event := &ConfigEvent{
+		Seq:        r.Seq,
+		Action:     r.Action,
+		Scope:      r.Scope,
+		Name:       r.Name,
+		Value:      r.Value,
+		Version:    r.Version,
+		UpdateUser: r.UpdateUser,
+		Time:       r.CreateTime,
+	}
+	if r.Action == RevisionActionDelete {
+		event.Value = nil
+		event.OldValue = r.Value
+	}
+	return event
+}
+
+// createRevision records the current state of c, must be called in the same
+// transaction as the write to configs.
+func createRevision(tx *sqlx.Tx, c *Config, action string) error {
+	valueStr, _ := json.Marshal(c.Value)
+	changeValueStr, _ := json.Marshal(c.ChangedValue)
+
+	_, err := tx.Exec("INSERT INTO config_revisions(config_id, scope, name, action, value, changed_value, update_user, version, seq) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		c.ID, c.Scope, c.Name, action, valueStr, changeValueStr, c.UpdateUser, c.Version, c.Seq)
+
+	return err
+}
+
+// GetConfigRevisions returns the revisions of a config, newest first
+func GetConfigRevisions(scope, name string) ([]*ConfigRevision, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]*ConfigRevision, 0)
+	err = db.Select(&revisions, "SELECT * FROM config_revisions WHERE scope = ? AND name = ? ORDER BY id DESC", scope, name)
+
+	return revisions, err
+}
+
+// GetConfigRevisionsAfterSeq reads at most limit entries of the changelog in
+// (seq, upto], ordered by sequence
+func GetConfigRevisionsAfterSeq(seq, upto int64, limit int) ([]*ConfigRevision, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]*ConfigRevision, 0)
+	err = db.Select(&revisions, "SELECT * FROM config_revisions WHERE seq > ? AND seq <= ? ORDER BY seq LIMIT ?", seq, upto, limit)
+
+	return revisions, err
+}
+
+func GetConfigRevision(scope, name string, id int) (*ConfigRevision, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var revision ConfigRevision
+	err = db.Get(&revision, "SELECT * FROM config_revisions WHERE id = ? AND scope = ? AND name = ?", id, scope, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRevisionNotFound
+	}
+
+	return &revision, err
+}
+
+// RollbackConfig restores the value of the given revision, the rollback is
+// recorded as a new revision. A deleted config is created again.
+func RollbackConfig(scope, name string, revisionID int, updateUser string) (*Config, error) {
+	revision, err := GetConfigRevision(scope, name, revisionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if revision.Action == RevisionActionDelete {
+		return nil, fmt.Errorf("can not rollback to a delete revision")
+	}
+
+	config, err := GetConfig(scope, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		config = NewConfig(scope, name, revision.Value, updateUser)
+		config.ChangedValue = revision.ChangedValue
+		return config, config.create(RevisionActionRollback)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config.Value = revision.Value
+	config.ChangedValue = revision.ChangedValue
+	config.UpdateUser = updateUser
+
+	return config, config.save(RevisionActionRollback)
+}
@@ -0,0 +1,18 @@
+package models
+
+import "testing"
+
+func TestConfigRevisionEvent(t *testing.T) {
+	value := JsonObject{"up_limit": 10.5}
+
+	event := (&ConfigRevision{Action: RevisionActionUpdate, Value: value}).Event()
+	if event.Value["up_limit"] != 10.5 || event.OldValue != nil {
+		t.Errorf("update event = %+v, want the new value", event)
+	}
+
+	// 与 config_outbox 中的删除事件一致
+	event = (&ConfigRevision{Action: RevisionActionDelete, Value: value}).Event()
+	if event.Value != nil || event.OldValue["up_limit"] != 10.5 {
+		t.Errorf("delete event = %+v, want nil value and the old value", event)
+	}
+}
@@ -0,0 +1,320 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	"quant_api/database"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/*
+CREATE TABLE `config_rollouts` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `scope` varchar(50) NOT NULL DEFAULT 'stock',
+  `name` varchar(50) NOT NULL DEFAULT 'default_name',
+  `value` json,
+  `clients` json,
+  `percent` int NOT NULL DEFAULT 0,
+  `status` varchar(20) NOT NULL DEFAULT 'active',
+  `seq` bigint NOT NULL DEFAULT 0,
+  `create_user` varchar(50) NOT NULL DEFAULT 'admin',
+  `update_user` varchar(50) NOT NULL DEFAULT 'admin',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY (`scope`, `name`),
+  KEY (`seq`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+*/
+
+const (
+	RolloutStatusActive   = "active"
+	RolloutStatusPromoted = "promoted"
+	RolloutStatusAborted  = "aborted"
+)
+
+var (
+	ErrRolloutNotFound   = errors.New("rollout not found")
+	ErrRolloutNotActive  = errors.New("rollout is not active")
+	ErrRolloutInProgress = errors.New("config already has an active rollout")
+)
+
+// Rollout 灰度发布, Value 为合并到当前配置上的变更, 只下发给 Clients 中的
+// 客户端以及按 Percent 比例选中的客户端. 状态变化时分配新的变更序号, 灰度
+// 客户端据此重新同步该配置.
+type Rollout struct {
+	ID         int        `db:"id" json:"id"`
+	Scope      string     `db:"scope" json:"scope"`
+	Name       string     `db:"name" json:"name"`
+	Value      JsonObject `db:"value" json:"value"`
+	Clients    StringList `db:"clients" json:"clients"`
+	Percent    int        `db:"percent" json:"percent"`
+	Status     string     `db:"status" json:"status"`
+	Seq        int64      `db:"seq" json:"seq"`
+	CreateUser string     `db:"create_user" json:"create_user"`
+	UpdateUser string     `db:"update_user" json:"update_user"`
+	CreateTime string     `db:"create_time" json:"create_time"`
+	UpdateTime string     `db:"update_time" json:"update_time"`
+} // @name Rollout
+
+// Targets reports whether the client is in the rollout, the percentage bucket
+// of a client is stable for the same rollout.
+func (r *Rollout) Targets(clientID string) bool {
+	for _, id := range r.Clients {
+		if id == clientID {
+			return true
+		}
+	}
+
+	if r.Percent <= 0 {
+		return false
+	}
+
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%d:%s", r.ID, clientID)
+	return int(h.Sum32()%100) < r.Percent
+}
+
+// Apply returns a copy of config with the rollout value merged
+func (r *Rollout) Apply(config *Config) *Config {
+	applied := *config
+	applied.Value = make(JsonObject, len(config.Value)+len(r.Value))
+	MergeObject(applied.Value, config.Value)
+	MergeObject(applied.Value, r.Value)
+	return &applied
+}
+
+// ApplyEvent returns a copy of event with the rollout value merged
+func (r *Rollout) ApplyEvent(event *ConfigEvent) *ConfigEvent {
+	applied := *event
+	applied.Value = make(JsonObject, len(event.Value)+len(r.Value))
+	MergeObject(applied.Value, event.Value)
+	MergeObject(applied.Value, r.Value)
+	return &applied
+}
+
+// ApplyRollouts returns configs as seen by the client, configs in an active
+// rollout targeting the client carry the rollout value.
+func ApplyRollouts(clientID string, configs []*Config, rollouts []*Rollout) []*Config {
+	targeted := make(map[string]*Rollout)
+	for _, r := range rollouts {
+		if r.Status == RolloutStatusActive && r.Targets(clientID) {
+			targeted[r.Scope+"/"+r.Name] = r
+		}
+	}
+	if len(targeted) == 0 {
+		return configs
+	}
+
+	applied := make([]*Config, 0, len(configs))
+	for _, config := range configs {
+		if r, ok := targeted[config.Scope+"/"+config.Name]; ok && !config.Deleted {
+			config = r.Apply(config)
+		}
+		applied = append(applied, config)
+	}
+	return applied
+}
+
+// Create starts the rollout, the config must exist and have no other active
+// rollout. The sequence lock taken first serializes the check with other
+// writers.
+func (r *Rollout) Create() error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seq, err := nextSeq(tx)
+	if err != nil {
+		return err
+	}
+
+	var configID int
+	if err := tx.Get(&configID, "SELECT id FROM configs WHERE scope = ? AND name = ? AND deleted_at IS NULL", r.Scope, r.Name); err != nil {
+		return err
+	}
+
+	var active int
+	if err := tx.Get(&active, "SELECT COUNT(*) FROM config_rollouts WHERE scope = ? AND name = ? AND status = ?", r.Scope, r.Name, RolloutStatusActive); err != nil {
+		return err
+	}
+	if active > 0 {
+		return ErrRolloutInProgress
+	}
+
+	if r.Clients == nil {
+		r.Clients = StringList{}
+	}
+	valueStr, _ := json.Marshal(r.Value)
+	clientsStr, _ := json.Marshal(r.Clients)
+
+	res, err := tx.Exec("INSERT INTO config_rollouts(scope, name, value, clients, percent, status, seq, create_user, update_user) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		r.Scope, r.Name, valueStr, clientsStr, r.Percent, RolloutStatusActive, seq, r.CreateUser, r.CreateUser)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Get(r, "SELECT * FROM config_rollouts WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	notifyChange(seq)
+
+	return nil
+}
+
+// Promote merges the rollout value into the config for every client
+func (r *Rollout) Promote(updateUser string) (*Config, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	seq, err := nextSeq(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.finish(tx, seq, RolloutStatusPromoted, updateUser); err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := tx.Get(&config, "SELECT * FROM configs WHERE scope = ? AND name = ? AND deleted_at IS NULL", r.Scope, r.Name); err != nil {
+		return nil, err
+	}
+
+	config.MergeValue(r.Value)
+	config.UpdateUser = updateUser
+	if err := config.update(tx, seq, RevisionActionPromote); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	notifyChange(seq)
+
+	return &config, nil
+}
+
+// Abort stops the rollout, the targeted clients get the current config again
+func (r *Rollout) Abort(updateUser string) error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seq, err := nextSeq(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := r.finish(tx, seq, RolloutStatusAborted, updateUser); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	notifyChange(seq)
+
+	return nil
+}
+
+func (r *Rollout) finish(tx *sqlx.Tx, seq int64, status, updateUser string) error {
+	res, err := tx.Exec("UPDATE config_rollouts SET status = ?, seq = ?, update_user = ? WHERE id = ? AND status = ?", status, seq, updateUser, r.ID, RolloutStatusActive)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrRolloutNotActive
+	}
+
+	r.Status = status
+	r.Seq = seq
+	r.UpdateUser = updateUser
+	return nil
+}
+
+func GetRollout(id int) (*Rollout, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var rollout Rollout
+	err = db.Get(&rollout, "SELECT * FROM config_rollouts WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRolloutNotFound
+	}
+
+	return &rollout, err
+}
+
+// GetRollouts returns the rollouts newest first, status is optional
+func GetRollouts(status string) ([]*Rollout, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := make([]*Rollout, 0)
+	if status == "" {
+		err = db.Select(&rollouts, "SELECT * FROM config_rollouts ORDER BY id DESC")
+	} else {
+		err = db.Select(&rollouts, "SELECT * FROM config_rollouts WHERE status = ? ORDER BY id DESC", status)
+	}
+
+	return rollouts, err
+}
+
+// GetRolloutsAfterSeq returns the rollouts whose status changed in (seq, upto]
+func GetRolloutsAfterSeq(seq, upto int64) ([]*Rollout, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts := make([]*Rollout, 0)
+	err = db.Select(&rollouts, "SELECT * FROM config_rollouts WHERE seq > ? AND seq <= ? ORDER BY seq", seq, upto)
+
+	return rollouts, err
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRolloutTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		rollout  Rollout
+		clientID string
+		want     bool
+	}{
+		{"0%", Rollout{ID: 1}, "node-1", false},
+		{"100%", Rollout{ID: 1, Percent: 100}, "node-1", true},
+		{"listed", Rollout{ID: 1, Clients: StringList{"node-1", "node-2"}}, "node-2", true},
+		{"not listed", Rollout{ID: 1, Clients: StringList{"node-1"}}, "node-3", false},
+		{"listed with 0%", Rollout{ID: 1, Clients: StringList{"node-1"}, Percent: 0}, "node-1", true},
+	}
+	for _, tt := range tests {
+		if got := tt.rollout.Targets(tt.clientID); got != tt.want {
+			t.Errorf("%s: Targets(%s) = %v, want %v", tt.name, tt.clientID, got, tt.want)
+		}
+	}
+}
+
+func TestRolloutTargetsPercent(t *testing.T) {
+	r := &Rollout{ID: 7, Percent: 30}
+	targeted := 0
+	for i := 0; i < 1000; i++ {
+		clientID := fmt.Sprintf("node-%d", i)
+		got := r.Targets(clientID)
+		if got != r.Targets(clientID) {
+			t.Fatalf("Targets(%s) is not stable", clientID)
+		}
+		if got {
+			targeted++
+		}
+	}
+	if targeted < 250 || targeted > 350 {
+		t.Errorf("targeted %d of 1000 clients, want about 300", targeted)
+	}
+}
+
+func TestApplyRollouts(t *testing.T) {
+	newConfigs := func() []*Config {
+		return []*Config{
+			{Scope: "stock", Name: "600000", Value: JsonObject{"up_limit": 10.5, "prod_status": true}},
+			{Scope: "stock", Name: "600001", Value: JsonObject{"up_limit": 9.5}},
+			{Scope: "stock", Name: "600002", Value: JsonObject{"up_limit": 8.5}, Deleted: true},
+		}
+	}
+	rollout := func(name, status string) *Rollout {
+		return &Rollout{ID: 1, Scope: "stock", Name: name, Value: JsonObject{"up_limit": 11.0}, Clients: StringList{"node-1"}, Status: status}
+	}
+
+	tests := []struct {
+		name     string
+		clientID string
+		rollouts []*Rollout
+		want     map[string]interface{}
+	}{
+		{"active", "node-1", []*Rollout{rollout("600000", RolloutStatusActive)}, map[string]interface{}{"600000": 11.0, "600001": 9.5, "600002": 8.5}},
+		{"not targeted", "node-2", []*Rollout{rollout("600000", RolloutStatusActive)}, map[string]interface{}{"600000": 10.5, "600001": 9.5, "600002": 8.5}},
+		{"aborted", "node-1", []*Rollout{rollout("600000", RolloutStatusAborted)}, map[string]interface{}{"600000": 10.5, "600001": 9.5, "600002": 8.5}},
+		{"promoted", "node-1", []*Rollout{rollout("600000", RolloutStatusPromoted)}, map[string]interface{}{"600000": 10.5, "600001": 9.5, "600002": 8.5}},
+		{"deleted", "node-1", []*Rollout{rollout("600002", RolloutStatusActive)}, map[string]interface{}{"600000": 10.5, "600001": 9.5, "600002": 8.5}},
+	}
+	for _, tt := range tests {
+		configs := newConfigs()
+		applied := ApplyRollouts(tt.clientID, configs, tt.rollouts)
+		for _, config := range applied {
+			if got := config.Value["up_limit"]; got != tt.want[config.Name] {
+				t.Errorf("%s: %s up_limit = %v, want %v", tt.name, config.Name, got, tt.want[config.Name])
+			}
+		}
+		// 灰度值合并到副本上, 不修改原配置, 其他字段保留
+		if configs[0].Value["up_limit"] != 10.5 || applied[0].Value["prod_status"] != true {
+			t.Errorf("%s: applied = %v, original = %v", tt.name, applied[0].Value, configs[0].Value)
+		}
+	}
+}
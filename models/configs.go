@@ -1,12 +1,16 @@
 package models
 
 import (
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"quant_api/database"
+
+	"github.com/jmoiron/sqlx"
 )
 
 /*
@@ -19,8 +23,12 @@ CREATE TABLE `configs` (
   `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
   `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
   `update_user` varchar(50) NOT NULL DEFAULT 'admin',
+  `version` int NOT NULL DEFAULT 1,
+  `deleted_at` timestamp NULL DEFAULT NULL,
+  `seq` bigint NOT NULL DEFAULT 0,
   PRIMARY KEY (`id`),
-  UNIQUE KEY (`scope`, `name`)
+  UNIQUE KEY (`scope`, `name`),
+  KEY (`seq`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
 */
 
@@ -33,8 +41,16 @@ type Config struct {
 	CreateTime   string     `db:"create_time" json:"create_time"`
 	UpdateTime   string     `db:"update_time" json:"update_time"`
 	UpdateUser   string     `db:"update_user" json:"update_user"`
+	Version      int        `db:"version" json:"version"`
+	DeletedAt    *string    `db:"deleted_at" json:"deleted_at,omitempty"`
+	Seq          int64      `db:"seq" json:"seq"`
+	Deleted      bool       `db:"-" json:"deleted"`
 } // @name Config
 
+// ErrVersionConflict is returned by Save when the config was changed by
+// someone else since it was loaded.
+var ErrVersionConflict = errors.New("config version conflict")
+
 type JsonObject map[string]interface{}
 
 func (pc *JsonObject) Scan(val interface{}) error {
@@ -55,6 +71,21 @@ func (pc *JsonObject) Value() (driver.Value, error) {
 	return json.Marshal(pc)
 }
 
+type StringList []string
+
+func (l *StringList) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case nil:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("Unsupported type: %T", v))
+	}
+}
+
 func NewConfig(scope, name string, value map[string]interface{}, updateUser string) *Config {
 	return &Config{
 		Scope:      scope,
@@ -114,43 +145,174 @@ func (c *Config) MergeValue(value map[string]interface{}) error {
 	return nil
 }
 
+// Delete marks the config as deleted, the row is kept as a tombstone so that
+// config center clients learn about the deletion. Tombstones are removed by
+// PurgeConfigTombstones.
 func (c *Config) Delete() error {
 	db, err := database.GetGlobalDB()
 	if err != nil {
 		return err
 	}
 
-	// use delete sql
-	_, err = db.Exec("DELETE FROM configs WHERE scope = ? AND name = ?", c.Scope, c.Name)
-	return err
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seq, err := nextSeq(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE configs SET deleted_at = CURRENT_TIMESTAMP, version = version + 1, seq = ?, update_user = ? WHERE scope = ? AND name = ? AND deleted_at IS NULL", seq, c.UpdateUser, c.Scope, c.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Get(c, "SELECT * FROM configs WHERE scope = ? AND name = ?", c.Scope, c.Name); err != nil {
+		return err
+	}
+	c.Deleted = true
+
+	if err := c.recordChange(tx, RevisionActionDelete, c.Value); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	notifyChange(c.Seq)
+
+	return nil
 }
 
 func (c *Config) Create() error {
+	return c.create(RevisionActionCreate)
+}
+
+func (c *Config) create(action string) error {
 	db, err := database.GetGlobalDB()
 	if err != nil {
 		return err
 	}
 
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seq, err := nextSeq(tx)
+	if err != nil {
+		return err
+	}
+
 	valueStr, _ := json.Marshal(c.Value)
+	changedValueStr := valueStr
+	if c.ChangedValue != nil {
+		changedValueStr, _ = json.Marshal(c.ChangedValue)
+	}
 
-	_, err = db.Exec("INSERT INTO configs(scope, name, value, changed_value, update_user) VALUES(?, ?, ?, ?, ?)", c.Scope, c.Name, valueStr, valueStr, c.UpdateUser)
+	// revive the tombstone if the config was deleted before
+	res, err := tx.Exec("UPDATE configs SET value = ?, changed_value = ?, update_user = ?, version = version + 1, seq = ?, deleted_at = NULL WHERE scope = ? AND name = ? AND deleted_at IS NOT NULL", valueStr, changedValueStr, c.UpdateUser, seq, c.Scope, c.Name)
+	if err != nil {
+		return err
+	}
 
-	return err
+	revived, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if revived == 0 {
+		_, err = tx.Exec("INSERT INTO configs(scope, name, value, changed_value, update_user, seq) VALUES(?, ?, ?, ?, ?, ?)", c.Scope, c.Name, valueStr, changedValueStr, c.UpdateUser, seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Get(c, "SELECT * FROM configs WHERE scope = ? AND name = ?", c.Scope, c.Name); err != nil {
+		return err
+	}
+
+	if err := c.recordChange(tx, action, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	notifyChange(c.Seq)
+
+	return nil
 }
 
 // Save the config to the database
 func (c *Config) Save() error {
+	return c.save(RevisionActionUpdate)
+}
+
+func (c *Config) save(action string) error {
 	db, err := database.GetGlobalDB()
 	if err != nil {
 		return err
 	}
 
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	seq, err := nextSeq(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.update(tx, seq, action); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	notifyChange(c.Seq)
+
+	return nil
+}
+
+// update writes c with the given sequence in tx
+func (c *Config) update(tx *sqlx.Tx, seq int64, action string) error {
+	// the sequence lock is held, the value can not change before the update
+	var oldValue JsonObject
+	err := tx.Get(&oldValue, "SELECT value FROM configs WHERE scope = ? AND name = ? AND deleted_at IS NULL", c.Scope, c.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrVersionConflict
+	}
+	if err != nil {
+		return err
+	}
+
 	valueStr, _ := json.Marshal(c.Value)
 	changeValueStr, _ := json.Marshal(c.ChangedValue)
 
-	_, err = db.Exec("UPDATE configs SET changed_value = ?, value = ?, update_user = ? WHERE scope = ? AND name = ?", changeValueStr, valueStr, c.UpdateUser, c.Scope, c.Name)
+	res, err := tx.Exec("UPDATE configs SET changed_value = ?, value = ?, update_user = ?, version = version + 1, seq = ? WHERE scope = ? AND name = ? AND version = ? AND deleted_at IS NULL", changeValueStr, valueStr, c.UpdateUser, seq, c.Scope, c.Name, c.Version)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	c.Version++
+	c.Seq = seq
 
-	return err
+	return c.recordChange(tx, action, oldValue)
 }
 
 // Reload the config from the database
@@ -160,7 +322,7 @@ func (c *Config) Reload() error {
 		return err
 	}
 
-	err = db.Get(c, "SELECT * FROM configs WHERE scope = ? AND name = ?", c.Scope, c.Name)
+	err = db.Get(c, "SELECT * FROM configs WHERE scope = ? AND name = ? AND deleted_at IS NULL", c.Scope, c.Name)
 
 	return err
 }
@@ -173,7 +335,7 @@ func GetConfig(scope, name string) (*Config, error) {
 	}
 
 	var config Config
-	err = db.Get(&config, "SELECT * FROM configs WHERE scope = ? AND name = ?", scope, name)
+	err = db.Get(&config, "SELECT * FROM configs WHERE scope = ? AND name = ? AND deleted_at IS NULL", scope, name)
 
 	return &config, err
 }
@@ -185,19 +347,68 @@ func GetConfigs(scope string) ([]*Config, error) {
 	}
 
 	configs := make([]*Config, 0)
-	err = db.Select(&configs, "SELECT * FROM configs WHERE scope = ?", scope)
+	err = db.Select(&configs, "SELECT * FROM configs WHERE scope = ? AND deleted_at IS NULL", scope)
 
 	return configs, err
 }
 
-func GetConfigsAfterTime(timeStamp int64) ([]*Config, error) {
+// GetConfigsAfterSeq returns the configs changed in (seq, upto] ordered by
+// sequence, deleted configs are included as tombstones with Deleted set.
+func GetConfigsAfterSeq(seq, upto int64) ([]*Config, error) {
 	db, err := database.GetGlobalDB()
 	if err != nil {
 		return nil, err
 	}
 
 	configs := make([]*Config, 0)
-	err = db.Select(&configs, "SELECT * FROM configs WHERE update_time > ?", timeStamp)
+	err = db.Select(&configs, "SELECT * FROM configs WHERE seq > ? AND seq <= ? ORDER BY seq", seq, upto)
+	markDeleted(configs)
 
 	return configs, err
 }
+
+func markDeleted(configs []*Config) {
+	for _, config := range configs {
+		config.Deleted = config.DeletedAt != nil
+	}
+}
+
+// PurgeConfigTombstones removes the tombstones older than retention and
+// records the highest purged sequence, see PurgedSeq
+func PurgeConfigTombstones(retention time.Duration) (int64, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	err = tx.Get(&seq, "SELECT COALESCE(MAX(seq), 0) FROM configs WHERE deleted_at IS NOT NULL AND deleted_at < NOW() - INTERVAL ? SECOND FOR UPDATE", int64(retention.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+	if seq == 0 {
+		return 0, nil
+	}
+
+	res, err := tx.Exec("DELETE FROM configs WHERE deleted_at IS NOT NULL AND seq <= ?", seq)
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE config_sequence SET purged_seq = GREATEST(purged_seq, ?) WHERE id = 1", seq)
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, tx.Commit()
+}
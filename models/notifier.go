@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"sync"
+)
+
+// changeNotifier wakes up the waiters when a config change is committed in
+// this process.
+type changeNotifier struct {
+	mu  sync.Mutex
+	seq int64
+	ch  chan struct{}
+}
+
+var notifier = &changeNotifier{ch: make(chan struct{})}
+
+// notifyChange must be called after the transaction of seq is committed
+func notifyChange(seq int64) {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	if seq > notifier.seq {
+		notifier.seq = seq
+	}
+	close(notifier.ch)
+	notifier.ch = make(chan struct{})
+}
+
+// WaitForChange blocks until a change newer than seq is committed or ctx is
+// done, returns false when ctx is done. Only changes written by this process
+// are observed, callers must query the database before waiting.
+func WaitForChange(ctx context.Context, seq int64) bool {
+	for {
+		notifier.mu.Lock()
+		latest, ch := notifier.seq, notifier.ch
+		notifier.mu.Unlock()
+
+		if latest > seq {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ch:
+		}
+	}
+}
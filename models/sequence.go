@@ -0,0 +1,59 @@
+package models
+
+import (
+	"quant_api/database"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/*
+CREATE TABLE `config_sequence` (
+  `id` tinyint NOT NULL,
+  `seq` bigint NOT NULL DEFAULT 0,
+  `purged_seq` bigint NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+INSERT INTO `config_sequence` (`id`, `seq`) VALUES (1, 0);
+*/
+
+// config_sequence.purged_seq 已清理的墓碑中最大的变更序号, 游标小于它的客户端
+// 无法通过增量同步得知这些删除, 需重新拉取快照
+
+// nextSeq allocates the next change sequence in tx. The sequence row stays
+// locked until tx finishes, so sequences become visible in commit order and a
+// reader never sees a gap that is filled later.
+func nextSeq(tx *sqlx.Tx) (int64, error) {
+	res, err := tx.Exec("UPDATE config_sequence SET seq = LAST_INSERT_ID(seq + 1) WHERE id = 1")
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// CurrentSeq returns the latest committed change sequence
+func CurrentSeq() (int64, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return 0, err
+	}
+
+	var seq int64
+	err = db.Get(&seq, "SELECT seq FROM config_sequence WHERE id = 1")
+
+	return seq, err
+}
+
+// PurgedSeq returns the highest sequence of the purged tombstones
+func PurgedSeq() (int64, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return 0, err
+	}
+
+	var seq int64
+	err = db.Get(&seq, "SELECT purged_seq FROM config_sequence WHERE id = 1")
+
+	return seq, err
+}
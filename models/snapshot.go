@@ -0,0 +1,89 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"quant_api/database"
+)
+
+// GetConfigSnapshot returns every live config together with the change
+// sequence they are consistent with, both are read in one snapshot
+// transaction so that syncing from seq afterwards misses nothing.
+func GetConfigSnapshot(ctx context.Context) ([]*Config, int64, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	var seq int64
+	if err := tx.Get(&seq, "SELECT seq FROM config_sequence WHERE id = 1"); err != nil {
+		return nil, 0, err
+	}
+
+	configs := make([]*Config, 0)
+	if err := tx.Select(&configs, "SELECT * FROM configs WHERE deleted_at IS NULL AND seq <= ? ORDER BY scope, name", seq); err != nil {
+		return nil, 0, err
+	}
+
+	return configs, seq, tx.Commit()
+}
+
+type hashEntry struct {
+	Scope string     `json:"scope"`
+	Name  string     `json:"name"`
+	Value JsonObject `json:"value"`
+}
+
+// HashConfigs returns the sha256 of the scope, name and value of configs,
+// independent of their order. Deleted configs are skipped.
+func HashConfigs(configs []*Config) string {
+	entries := make([]hashEntry, 0, len(configs))
+	for _, config := range configs {
+		if config.Deleted {
+			continue
+		}
+		entries = append(entries, hashEntry{Scope: config.Scope, Name: config.Name, Value: config.Value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Scope != entries[j].Scope {
+			return entries[i].Scope < entries[j].Scope
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	h := sha256.New()
+	for _, entry := range entries {
+		b, _ := json.Marshal(entry)
+		h.Write(b)
+		h.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HashConfigsByScope returns HashConfigs of the configs of every scope
+func HashConfigsByScope(configs []*Config) map[string]string {
+	scopes := make(map[string][]*Config)
+	for _, config := range configs {
+		if !config.Deleted {
+			scopes[config.Scope] = append(scopes[config.Scope], config)
+		}
+	}
+
+	hashes := make(map[string]string, len(scopes))
+	for scope, scopeConfigs := range scopes {
+		hashes[scope] = HashConfigs(scopeConfigs)
+	}
+	return hashes
+}
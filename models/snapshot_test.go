@@ -0,0 +1,50 @@
+package models
+
+import "testing"
+
+func TestHashConfigs(t *testing.T) {
+	a := &Config{Scope: "stock", Name: "600000", Value: JsonObject{"up_limit": 10.5}}
+	b := &Config{Scope: "stock", Name: "600001", Value: JsonObject{"up_limit": 9.5}}
+	c := &Config{Scope: "global", Name: "default", Value: JsonObject{"broker": "xt"}}
+	deleted := &Config{Scope: "stock", Name: "600002", Value: JsonObject{"up_limit": 8.5}, Deleted: true}
+	changed := &Config{Scope: "stock", Name: "600000", Value: JsonObject{"up_limit": 11.0}}
+
+	want := HashConfigs([]*Config{a, b, c})
+	tests := []struct {
+		name    string
+		configs []*Config
+		same    bool
+	}{
+		{"reordered", []*Config{c, b, a}, true},
+		{"deleted excluded", []*Config{a, deleted, b, c}, true},
+		{"missing", []*Config{a, b}, false},
+		{"value changed", []*Config{changed, b, c}, false},
+	}
+	for _, tt := range tests {
+		if got := HashConfigs(tt.configs); (got == want) != tt.same {
+			t.Errorf("%s: HashConfigs() = %s, want same = %v", tt.name, got, tt.same)
+		}
+	}
+
+	if HashConfigs(nil) != HashConfigs([]*Config{deleted}) {
+		t.Errorf("only deleted configs should hash as empty")
+	}
+}
+
+func TestHashConfigsByScope(t *testing.T) {
+	a := &Config{Scope: "stock", Name: "600000", Value: JsonObject{"up_limit": 10.5}}
+	b := &Config{Scope: "stock", Name: "600001", Value: JsonObject{"up_limit": 9.5}}
+	c := &Config{Scope: "global", Name: "default", Value: JsonObject{"broker": "xt"}}
+	deleted := &Config{Scope: "account", Name: "main", Value: JsonObject{}, Deleted: true}
+
+	hashes := HashConfigsByScope([]*Config{b, c, deleted, a})
+	if len(hashes) != 2 {
+		t.Fatalf("HashConfigsByScope() = %v, want stock and global only", hashes)
+	}
+	if hashes["stock"] != HashConfigs([]*Config{a, b}) {
+		t.Errorf("stock hash = %s, want HashConfigs of the stock configs", hashes["stock"])
+	}
+	if hashes["global"] != HashConfigs([]*Config{c}) {
+		t.Errorf("global hash = %s, want HashConfigs of the global configs", hashes["global"])
+	}
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Subscription 订阅 scope 下的配置, Names 与 Prefix 都为空时订阅整个 scope
+type Subscription struct {
+	Scope  string   `json:"scope"`
+	Names  []string `json:"names,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+}
+
+func (s Subscription) Match(scope, name string) bool {
+	if s.Scope != scope {
+		return false
+	}
+	if len(s.Names) == 0 && s.Prefix == "" {
+		return true
+	}
+	if s.Prefix != "" && strings.HasPrefix(name, s.Prefix) {
+		return true
+	}
+	for _, n := range s.Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscriptions 为空时订阅全部配置
+type Subscriptions []Subscription
+
+func (s Subscriptions) Match(scope, name string) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, sub := range s {
+		if sub.Match(scope, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether every config matched by other is also matched by s
+func (s Subscriptions) Covers(other Subscriptions) bool {
+	if len(s) == 0 {
+		return true
+	}
+	if len(other) == 0 {
+		return false
+	}
+	for _, sub := range other {
+		if !s.covers(sub) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Subscriptions) covers(sub Subscription) bool {
+	for _, o := range s {
+		if o.Scope == sub.Scope && len(o.Names) == 0 && o.Prefix == "" {
+			return true
+		}
+	}
+	if len(sub.Names) == 0 && sub.Prefix == "" {
+		return false
+	}
+
+	if sub.Prefix != "" {
+		covered := false
+		for _, o := range s {
+			if o.Scope == sub.Scope && o.Prefix != "" && strings.HasPrefix(sub.Prefix, o.Prefix) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	for _, name := range sub.Names {
+		if !s.Match(sub.Scope, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the configs matching the subscriptions
+func (s Subscriptions) Filter(configs []*Config) []*Config {
+	if len(s) == 0 {
+		return configs
+	}
+
+	filtered := make([]*Config, 0, len(configs))
+	for _, config := range configs {
+		if s.Match(config.Scope, config.Name) {
+			filtered = append(filtered, config)
+		}
+	}
+	return filtered
+}
+
+func (s *Subscriptions) Scan(val interface{}) error {
+	switch v := val.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("Unsupported type: %T", v)
+	}
+}
@@ -0,0 +1,38 @@
+package models
+
+import "testing"
+
+func TestSubscriptionsCovers(t *testing.T) {
+	stock := Subscription{Scope: "stock"}
+	global := Subscription{Scope: "global"}
+	prefix60 := Subscription{Scope: "stock", Prefix: "60"}
+	prefix600 := Subscription{Scope: "stock", Prefix: "600"}
+	names := Subscription{Scope: "stock", Names: []string{"600000", "600001"}}
+
+	tests := []struct {
+		name  string
+		s     Subscriptions
+		other Subscriptions
+		want  bool
+	}{
+		{"all covers all", nil, nil, true},
+		{"all covers scope", nil, Subscriptions{stock}, true},
+		{"scope does not cover all", Subscriptions{stock}, nil, false},
+		{"scope covers prefix", Subscriptions{stock}, Subscriptions{prefix600}, true},
+		{"scope covers names", Subscriptions{stock}, Subscriptions{names}, true},
+		{"other scope", Subscriptions{stock}, Subscriptions{global}, false},
+		{"prefix does not cover scope", Subscriptions{prefix60}, Subscriptions{stock}, false},
+		{"shorter prefix covers longer", Subscriptions{prefix60}, Subscriptions{prefix600}, true},
+		{"longer prefix does not cover shorter", Subscriptions{prefix600}, Subscriptions{prefix60}, false},
+		{"prefix covers matching names", Subscriptions{prefix600}, Subscriptions{names}, true},
+		{"names do not cover prefix", Subscriptions{names}, Subscriptions{prefix600}, false},
+		{"names cover subset", Subscriptions{names}, Subscriptions{{Scope: "stock", Names: []string{"600001"}}}, true},
+		{"names do not cover other names", Subscriptions{names}, Subscriptions{{Scope: "stock", Names: []string{"000001"}}}, false},
+		{"several subscriptions", Subscriptions{stock, global}, Subscriptions{global, names}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.s.Covers(tt.other); got != tt.want {
+			t.Errorf("%s: Covers() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,22 +1,51 @@
 package models
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
 	"quant_api/database"
 )
 
+/*
+CREATE TABLE `sync_status` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `client_id` varchar(100) NOT NULL,
+  `update_time` bigint NOT NULL DEFAULT 0,
+  `delivered_cursor` bigint NOT NULL DEFAULT 0,
+  `applied_cursor` bigint NOT NULL DEFAULT 0,
+  `applied_time` bigint NOT NULL DEFAULT 0,
+  `applied_hash` varchar(64) NOT NULL DEFAULT '',
+  `scope_hashes` json,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY (`client_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+*/
+
+// SyncStatus 记录客户端的同步位置, delivered 为最近一次下发到的变更序号,
+// applied 为客户端确认已应用的变更序号, AppliedHash 与 ScopeHashes 为客户端
+// 上报的已应用配置的校验值, 见 HashConfigs
 type SyncStatus struct {
-	ID         int    `json:"id" gorm:"primary_key"`
-	ClientID   string `json:"client_id"`
-	UpdateTime int64  `json:"update_time"`
+	ID              int    `db:"id" json:"id"`
+	ClientID        string `db:"client_id" json:"client_id"`
+	UpdateTime      int64  `db:"update_time" json:"update_time"`
+	DeliveredCursor int64  `db:"delivered_cursor" json:"delivered_cursor"`
+	AppliedCursor   int64  `db:"applied_cursor" json:"applied_cursor"`
+	AppliedTime     int64  `db:"applied_time" json:"applied_time"`
+
+	AppliedHash string     `db:"applied_hash" json:"applied_hash"`
+	ScopeHashes JsonObject `db:"scope_hashes" json:"scope_hashes"`
 }
 
-func (s SyncStatus) Create() error {
+func (s *SyncStatus) Create() error {
 	db, err := database.GetGlobalDB()
 	if err != nil {
 		return err
 	}
 
-	res, err := db.Exec("insert into sync_status (client_id, update_time) values (?, ?)", s.ClientID, s.UpdateTime)
+	res, err := db.Exec("insert into sync_status (client_id, update_time, delivered_cursor, applied_cursor, applied_time) values (?, ?, ?, ?, ?)", s.ClientID, s.UpdateTime, s.DeliveredCursor, s.AppliedCursor, s.AppliedTime)
 	if err != nil {
 		return err
 	}
@@ -30,40 +59,64 @@ func (s SyncStatus) Create() error {
 	return nil
 }
 
-func (s SyncStatus) Save() error {
+// Save the delivered position
+func (s *SyncStatus) Save() error {
 	db, err := database.GetGlobalDB()
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec("update sync_status set update_time = ? where client_id = ?", s.UpdateTime, s.ClientID)
+	_, err = db.Exec("update sync_status set update_time = ?, delivered_cursor = ? where client_id = ?", s.UpdateTime, s.DeliveredCursor, s.ClientID)
 	return err
 }
 
-func LoadSyncStatus(clientID string) (syncStatus *SyncStatus, err error) {
+// Ack advances the applied position and records the hashes of the applied
+// configs, an older cursor than the stored one is ignored so that a late ack
+// can not move the position back.
+func (s *SyncStatus) Ack(cursor int64, hash string, scopeHashes map[string]interface{}) error {
 	db, err := database.GetGlobalDB()
 	if err != nil {
-		return syncStatus, err
+		return err
 	}
 
-	if err := db.Select(syncStatus, "select * from sync_status where client_id = ?", clientID); err != nil {
-		return syncStatus, err
+	appliedTime := time.Now().Unix()
+	scopeHashesStr, _ := json.Marshal(scopeHashes)
+	res, err := db.Exec("update sync_status set applied_cursor = ?, applied_time = ?, applied_hash = ?, scope_hashes = ? where client_id = ? and applied_cursor <= ?", cursor, appliedTime, hash, scopeHashesStr, s.ClientID, cursor)
+	if err != nil {
+		return err
 	}
 
-	if syncStatus != nil {
-		return syncStatus, nil
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
 	}
-
-	// create new sync status and save
-	syncStatus = &SyncStatus{
-		ClientID:   clientID,
-		UpdateTime: 0,
+	if affected > 0 {
+		s.AppliedCursor = cursor
+		s.AppliedTime = appliedTime
+		s.AppliedHash = hash
+		s.ScopeHashes = scopeHashes
 	}
 
-	if err := syncStatus.Create(); err != nil {
+	return nil
+}
+
+// LoadSyncStatus returns ErrClientNotRegistered for unknown clients, the sync
+// status is created on registration.
+func LoadSyncStatus(clientID string) (syncStatus *SyncStatus, err error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
 		return syncStatus, err
 	}
 
+	syncStatus = &SyncStatus{}
+	err = db.Get(syncStatus, "select * from sync_status where client_id = ?", clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrClientNotRegistered
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	return syncStatus, nil
 }
 
@@ -73,7 +126,8 @@ func LoadAllSyncStatus() (syncStatus []*SyncStatus, err error) {
 		return syncStatus, err
 	}
 
-	if err := db.Select(syncStatus, "select * from sync_status"); err != nil {
+	syncStatus = make([]*SyncStatus, 0)
+	if err := db.Select(&syncStatus, "select * from sync_status"); err != nil {
 		return syncStatus, err
 	}
 
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"quant_api/database"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/*
+CREATE TABLE `webhooks` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `url` varchar(500) NOT NULL,
+  `secret` varchar(200) NOT NULL DEFAULT '',
+  `scopes` json,
+  `names` json,
+  `events` json,
+  `enabled` tinyint(1) NOT NULL DEFAULT 1,
+  `create_user` varchar(50) NOT NULL DEFAULT 'admin',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+
+CREATE TABLE `webhook_deliveries` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `webhook_id` int NOT NULL,
+  `event_id` varchar(50) NOT NULL,
+  `event_type` varchar(50) NOT NULL,
+  `payload` json,
+  `status` varchar(20) NOT NULL DEFAULT 'pending',
+  `attempts` int NOT NULL DEFAULT 0,
+  `next_attempt` bigint NOT NULL DEFAULT 0,
+  `response_code` int NOT NULL DEFAULT 0,
+  `last_error` varchar(500) NOT NULL DEFAULT '',
+  `create_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `update_time` timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  PRIMARY KEY (`id`),
+  KEY (`webhook_id`),
+  KEY (`status`, `next_attempt`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci
+*/
+
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSuccess = "success"
+	DeliveryStatusFailed  = "failed"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Webhook 订阅事件的回调地址, Scopes、Names、Events 为空时不过滤
+type Webhook struct {
+	ID         int        `db:"id" json:"id"`
+	URL        string     `db:"url" json:"url"`
+	Secret     string     `db:"secret" json:"-"`
+	Scopes     StringList `db:"scopes" json:"scopes"`
+	Names      StringList `db:"names" json:"names"`
+	Events     StringList `db:"events" json:"events"`
+	Enabled    bool       `db:"enabled" json:"enabled"`
+	CreateUser string     `db:"create_user" json:"create_user"`
+	CreateTime string     `db:"create_time" json:"create_time"`
+	UpdateTime string     `db:"update_time" json:"update_time"`
+} // @name Webhook
+
+func (w *Webhook) Create() error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range []*StringList{&w.Scopes, &w.Names, &w.Events} {
+		if *l == nil {
+			*l = StringList{}
+		}
+	}
+	scopesStr, _ := json.Marshal(w.Scopes)
+	namesStr, _ := json.Marshal(w.Names)
+	eventsStr, _ := json.Marshal(w.Events)
+
+	res, err := db.Exec("INSERT INTO webhooks(url, secret, scopes, names, events, enabled, create_user) VALUES(?, ?, ?, ?, ?, 1, ?)",
+		w.URL, w.Secret, scopesStr, namesStr, eventsStr, w.CreateUser)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	return db.Get(w, "SELECT * FROM webhooks WHERE id = ?", id)
+}
+
+func DeleteWebhook(id int) error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	res, err := db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func GetWebhook(id int) (*Webhook, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var webhook Webhook
+	err = db.Get(&webhook, "SELECT * FROM webhooks WHERE id = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+
+	return &webhook, err
+}
+
+func GetWebhooks() ([]*Webhook, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]*Webhook, 0)
+	err = db.Select(&webhooks, "SELECT * FROM webhooks ORDER BY id")
+
+	return webhooks, err
+}
+
+// WebhookDelivery 一次事件投递, 失败后按 NextAttempt 重试
+type WebhookDelivery struct {
+	ID           int64      `db:"id" json:"id"`
+	WebhookID    int        `db:"webhook_id" json:"webhook_id"`
+	EventID      string     `db:"event_id" json:"event_id"`
+	EventType    string     `db:"event_type" json:"event_type"`
+	Payload      JsonObject `db:"payload" json:"payload"`
+	Status       string     `db:"status" json:"status"`
+	Attempts     int        `db:"attempts" json:"attempts"`
+	NextAttempt  int64      `db:"next_attempt" json:"next_attempt"`
+	ResponseCode int        `db:"response_code" json:"response_code"`
+	LastError    string     `db:"last_error" json:"last_error"`
+	CreateTime   string     `db:"create_time" json:"create_time"`
+	UpdateTime   string     `db:"update_time" json:"update_time"`
+} // @name WebhookDelivery
+
+// CreateWebhookDelivery queues the payload for the webhook, payload is the
+// raw json body that will be posted.
+func CreateWebhookDelivery(webhookID int, eventID, eventType string, payload []byte) error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO webhook_deliveries(webhook_id, event_id, event_type, payload, status, next_attempt) VALUES(?, ?, ?, ?, ?, ?)",
+		webhookID, eventID, eventType, payload, DeliveryStatusPending, time.Now().Unix())
+
+	return err
+}
+
+// Save the result of an attempt
+func (d *WebhookDelivery) Save() error {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt = ?, response_code = ?, last_error = ? WHERE id = ?",
+		d.Status, d.Attempts, d.NextAttempt, d.ResponseCode, d.LastError, d.ID)
+
+	return err
+}
+
+// ClaimDueWebhookDeliveries returns the pending deliveries whose next attempt
+// is due and postpones them by lease, so that other quant_api instances skip
+// them while they are being sent. A delivery whose sender died is retried
+// after the lease.
+func ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	deliveries := make([]*WebhookDelivery, 0)
+	err = tx.Select(&deliveries, "SELECT * FROM webhook_deliveries WHERE status = ? AND next_attempt <= ? ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED",
+		DeliveryStatusPending, now, limit)
+	if err != nil || len(deliveries) == 0 {
+		return deliveries, err
+	}
+
+	ids := make([]int64, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+	}
+	query, args, err := sqlx.In("UPDATE webhook_deliveries SET next_attempt = ? WHERE id IN (?)", now+int64(lease.Seconds()), ids)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return nil, err
+	}
+
+	return deliveries, tx.Commit()
+}
+
+// GetWebhookDeliveries returns the deliveries of the webhook, newest first
+func GetWebhookDeliveries(webhookID int, status string, limit int) ([]*WebhookDelivery, error) {
+	db, err := database.GetGlobalDB()
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*WebhookDelivery, 0)
+	if status == "" {
+		err = db.Select(&deliveries, "SELECT * FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?", webhookID, limit)
+	} else {
+		err = db.Select(&deliveries, "SELECT * FROM webhook_deliveries WHERE webhook_id = ? AND status = ? ORDER BY id DESC LIMIT ?", webhookID, status, limit)
+	}
+
+	return deliveries, err
+}
@@ -0,0 +1,212 @@
+// Package outbox 把 config_outbox 中的配置变更事件按顺序投递给各个 Sink.
+// 事件与配置写入在同一事务中落库, 每个 Sink 独立记录投递位置, 失败时只有该
+// Sink 按指数退避重试, 不影响其他 Sink. 下游收到的事件至少一次且按 seq 有序,
+// 重复的事件需由下游按 seq 去重.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"quant_api/models"
+	"quant_api/utils"
+)
+
+const (
+	baseBackoff  = time.Second
+	maxBackoff   = 5 * time.Minute
+	pollInterval = 5 * time.Second
+	pollBatch    = 100
+)
+
+// Sink 事件的下游, Send 返回 nil 才视为投递成功. Name 用于记录投递位置,
+// 需唯一且稳定
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, event *models.ConfigEvent) error
+}
+
+// Store 读写 outbox 表, 测试中可替换
+type Store interface {
+	After(id int64, limit int) ([]*models.OutboxMessage, error)
+	MarkDone(id int64) error
+	Sink(name string) (*models.OutboxSink, error)
+	SaveSink(sink *models.OutboxSink) error
+}
+
+type dbStore struct{}
+
+func (dbStore) After(id int64, limit int) ([]*models.OutboxMessage, error) {
+	return models.GetOutboxMessagesAfter(id, limit)
+}
+
+func (dbStore) MarkDone(id int64) error {
+	return models.MarkOutboxMessagesDone(id)
+}
+
+func (dbStore) Sink(name string) (*models.OutboxSink, error) {
+	return models.GetOutboxSink(name)
+}
+
+func (dbStore) SaveSink(sink *models.OutboxSink) error {
+	return sink.Save()
+}
+
+type Dispatcher struct {
+	logger *slog.Logger
+	store  Store
+	sinks  []Sink
+	now    func() time.Time
+}
+
+func NewDispatcher(logger *slog.Logger, sinks ...Sink) *Dispatcher {
+	return newDispatcher(logger, dbStore{}, sinks...)
+}
+
+func newDispatcher(logger *slog.Logger, store Store, sinks ...Sink) *Dispatcher {
+	return &Dispatcher{
+		logger: logger.With("component", "outbox"),
+		store:  store,
+		sinks:  sinks,
+		now:    time.Now,
+	}
+}
+
+// Run drains the outbox until ctx is done. Changes committed by this process
+// wake the dispatcher at once, other writers and retries are picked up by
+// polling.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var seen int64
+	for {
+		seq, err := d.Drain(ctx)
+		if err != nil {
+			d.logger.Error("drain outbox failed", "error", err)
+		}
+		if seq > seen {
+			seen = seq
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, pollInterval)
+		models.WaitForChange(waitCtx, seen)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Drain dispatches the pending messages to every sink concurrently and
+// returns the largest sequence read. A sink stops at its first failure, so
+// that later events never overtake it, while the other sinks go on. Messages
+// delivered to every sink are marked done.
+func (d *Dispatcher) Drain(ctx context.Context) (int64, error) {
+	seqs := make([]int64, len(d.sinks))
+	delivered := make([]int64, len(d.sinks))
+	errs := make([]error, len(d.sinks))
+
+	var wg sync.WaitGroup
+	for i, sink := range d.sinks {
+		wg.Add(1)
+		go func(i int, sink Sink) {
+			defer wg.Done()
+			seqs[i], delivered[i], errs[i] = d.drainSink(ctx, sink)
+		}(i, sink)
+	}
+	wg.Wait()
+
+	var seq int64
+	for _, s := range seqs {
+		seq = max(seq, s)
+	}
+
+	// 一个 Sink 的位置读取失败时为 0, 不会标记
+	err := errors.Join(errs...)
+	if len(delivered) > 0 {
+		done := delivered[0]
+		for _, id := range delivered[1:] {
+			done = min(done, id)
+		}
+		if done > 0 {
+			if markErr := d.store.MarkDone(done); markErr != nil {
+				err = errors.Join(err, fmt.Errorf("mark outbox done: %w", markErr))
+			}
+		}
+	}
+
+	return seq, err
+}
+
+// drainSink sends the messages after the position of sink, it returns the
+// largest sequence read and the new position
+func (d *Dispatcher) drainSink(ctx context.Context, sink Sink) (int64, int64, error) {
+	state, err := d.store.Sink(sink.Name())
+	if err != nil {
+		return 0, 0, fmt.Errorf("load sink %s: %w", sink.Name(), err)
+	}
+	if state.NextAttempt > d.now().Unix() {
+		return 0, state.DeliveredID, nil
+	}
+
+	var seq int64
+	for {
+		messages, err := d.store.After(state.DeliveredID, pollBatch)
+		if err != nil {
+			return seq, state.DeliveredID, err
+		}
+
+		saved := state.DeliveredID
+		for _, message := range messages {
+			seq = max(seq, message.Seq)
+			if ctx.Err() != nil {
+				break
+			}
+
+			if err := sink.Send(ctx, &message.Event); err != nil {
+				return seq, state.DeliveredID, d.fail(sink, state, message, err)
+			}
+			state.DeliveredID = message.ID
+			state.Attempts = 0
+			state.NextAttempt = 0
+			state.LastError = ""
+		}
+
+		if state.DeliveredID != saved {
+			if err := d.store.SaveSink(state); err != nil {
+				return seq, saved, fmt.Errorf("save sink %s: %w", sink.Name(), err)
+			}
+		}
+
+		if ctx.Err() != nil || len(messages) < pollBatch {
+			return seq, state.DeliveredID, nil
+		}
+	}
+}
+
+// fail records the failed attempt together with the position reached before
+// message
+func (d *Dispatcher) fail(sink Sink, state *models.OutboxSink, message *models.OutboxMessage, sendErr error) error {
+	state.Attempts++
+	state.NextAttempt = d.now().Add(backoff(state.Attempts)).Unix()
+	state.LastError = utils.Truncate(sendErr.Error(), 500)
+	d.logger.Warn("outbox dispatch failed", "sink", sink.Name(), "id", message.ID, "seq", message.Seq, "attempts", state.Attempts, "error", sendErr)
+
+	err := fmt.Errorf("sink %s: %w", sink.Name(), sendErr)
+	if saveErr := d.store.SaveSink(state); saveErr != nil {
+		err = errors.Join(err, fmt.Errorf("save sink %s: %w", sink.Name(), saveErr))
+	}
+	return err
+}
+
+// backoff 第 n 次失败后的等待时间, 1s 起按 2 倍增长
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff << (attempts - 1)
+	if wait <= 0 || wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,156 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"quant_api/models"
+)
+
+type fakeStore struct {
+	mu       sync.Mutex
+	messages []*models.OutboxMessage
+	sinks    map[string]*models.OutboxSink
+}
+
+func (f *fakeStore) add(seq int64) {
+	f.messages = append(f.messages, &models.OutboxMessage{
+		ID:     int64(len(f.messages) + 1),
+		Seq:    seq,
+		Event:  models.ConfigEvent{Seq: seq, Scope: "stock", Name: "600000"},
+		Status: models.OutboxStatusPending,
+	})
+}
+
+func (f *fakeStore) After(id int64, limit int) ([]*models.OutboxMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messages := make([]*models.OutboxMessage, 0)
+	for _, m := range f.messages {
+		if m.ID > id && len(messages) < limit {
+			copied := *m
+			messages = append(messages, &copied)
+		}
+	}
+	return messages, nil
+}
+
+func (f *fakeStore) MarkDone(id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, m := range f.messages {
+		if m.ID <= id {
+			m.Status = models.OutboxStatusDone
+		}
+	}
+	return nil
+}
+
+func (f *fakeStore) Sink(name string) (*models.OutboxSink, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.sinks == nil {
+		f.sinks = make(map[string]*models.OutboxSink)
+	}
+	if _, ok := f.sinks[name]; !ok {
+		f.sinks[name] = &models.OutboxSink{Sink: name}
+	}
+	copied := *f.sinks[name]
+	return &copied, nil
+}
+
+func (f *fakeStore) SaveSink(sink *models.OutboxSink) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	copied := *sink
+	f.sinks[sink.Sink] = &copied
+	return nil
+}
+
+// flakySink 在 failSeq 上失败 failures 次, 记录投递成功的 seq
+type flakySink struct {
+	failSeq  int64
+	failures int
+	sent     []int64
+}
+
+func (s *flakySink) Name() string {
+	return "flaky"
+}
+
+func (s *flakySink) Send(ctx context.Context, event *models.ConfigEvent) error {
+	if event.Seq == s.failSeq && s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.sent = append(s.sent, event.Seq)
+	return nil
+}
+
+func TestDispatcherDrain(t *testing.T) {
+	store := &fakeStore{}
+	for seq := int64(1); seq <= 3; seq++ {
+		store.add(seq)
+	}
+
+	memory := NewMemorySink()
+	flaky := &flakySink{failSeq: 2, failures: 1}
+	d := newDispatcher(slog.Default(), store, flaky, memory)
+
+	now := time.Unix(1000, 0)
+	d.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	seq, err := d.Drain(ctx)
+	if err == nil {
+		t.Fatalf("Drain() error = nil, want sink error")
+	}
+	if seq != 3 {
+		t.Errorf("Drain() seq = %d, want 3", seq)
+	}
+	// 失败的 Sink 不阻塞其他 Sink
+	if got := len(memory.Events()); got != 3 {
+		t.Errorf("memory sink got %d events, want 3", got)
+	}
+	if got := store.sinks["flaky"]; got.DeliveredID != 1 || got.Attempts != 1 || got.NextAttempt != now.Add(baseBackoff).Unix() {
+		t.Errorf("flaky sink delivered = %d, attempts = %d, next attempt = %d", got.DeliveredID, got.Attempts, got.NextAttempt)
+	}
+	if store.messages[0].Status != models.OutboxStatusDone || store.messages[1].Status != models.OutboxStatusPending {
+		t.Errorf("message status = %s, %s, want done, pending", store.messages[0].Status, store.messages[1].Status)
+	}
+
+	// 重试时间未到时失败的 Sink 不投递, 其他 Sink 也不重复投递
+	if _, err := d.Drain(ctx); err != nil {
+		t.Errorf("Drain() before retry error = %v", err)
+	}
+	if len(flaky.sent) != 1 || len(memory.Events()) != 3 {
+		t.Errorf("before retry flaky sent %v, memory got %d events", flaky.sent, len(memory.Events()))
+	}
+
+	now = now.Add(baseBackoff)
+	if _, err := d.Drain(ctx); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+
+	for i, seq := range flaky.sent {
+		if seq != int64(i+1) {
+			t.Errorf("flaky sink event %d seq = %d, want %d", i, seq, i+1)
+		}
+	}
+	if len(flaky.sent) != 3 || len(memory.Events()) != 3 {
+		t.Errorf("flaky sent %v, memory got %d events, want 3 each", flaky.sent, len(memory.Events()))
+	}
+	for _, m := range store.messages {
+		if m.Status != models.OutboxStatusDone {
+			t.Errorf("message %d status = %s, want done", m.ID, m.Status)
+		}
+	}
+}
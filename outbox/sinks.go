@@ -0,0 +1,59 @@
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"quant_api/models"
+)
+
+// LogSink 把事件写入日志
+type LogSink struct {
+	logger *slog.Logger
+}
+
+func NewLogSink(logger *slog.Logger) *LogSink {
+	return &LogSink{logger: logger.With("sink", "log")}
+}
+
+func (s *LogSink) Name() string {
+	return "log"
+}
+
+func (s *LogSink) Send(ctx context.Context, event *models.ConfigEvent) error {
+	s.logger.Info("config changed", "seq", event.Seq, "action", event.Action, "scope", event.Scope, "name", event.Name,
+		"version", event.Version, "update_user", event.UpdateUser)
+	return nil
+}
+
+// MemorySink 在内存中保存收到的事件, 供测试使用
+type MemorySink struct {
+	mu     sync.Mutex
+	events []*models.ConfigEvent
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Name() string {
+	return "memory"
+}
+
+func (s *MemorySink) Send(ctx context.Context, event *models.ConfigEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *event
+	s.events = append(s.events, &copied)
+	return nil
+}
+
+// Events returns the received events in order
+func (s *MemorySink) Events() []*models.ConfigEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*models.ConfigEvent(nil), s.events...)
+}
@@ -0,0 +1,92 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrClosed = errors.New("publisher: closed")
+
+// Loopback 进程内发布, Publish 在所有订阅者收到事件或 ctx 结束前阻塞
+type Loopback struct {
+	mu     sync.Mutex
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+type subscription struct {
+	ch   chan *Event
+	done chan struct{}
+	once sync.Once
+}
+
+func (s *subscription) cancel() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func NewLoopback() *Loopback {
+	return &Loopback{subs: make(map[*subscription]struct{})}
+}
+
+// Subscribe returns the events published from now on. The channel is never
+// closed, stop reading it after calling cancel.
+func (l *Loopback) Subscribe(buffer int) (<-chan *Event, func()) {
+	sub := &subscription{
+		ch:   make(chan *Event, buffer),
+		done: make(chan struct{}),
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		sub.cancel()
+		return sub.ch, func() {}
+	}
+	l.subs[sub] = struct{}{}
+
+	return sub.ch, func() {
+		l.mu.Lock()
+		delete(l.subs, sub)
+		l.mu.Unlock()
+		sub.cancel()
+	}
+}
+
+func (l *Loopback) Publish(ctx context.Context, event *Event) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+	subs := make([]*subscription, 0, len(l.subs))
+	for sub := range l.subs {
+		subs = append(subs, sub)
+	}
+	l.mu.Unlock()
+
+	for _, sub := range subs {
+		select {
+		case sub.ch <- event:
+		case <-sub.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (l *Loopback) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for sub := range l.subs {
+		sub.cancel()
+		delete(l.subs, sub)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+// Package publisher 把配置变更发布到消息总线, 供已接入总线的服务订阅, 无需
+// 轮询 /config_center/configs. 事件来自 outbox, 至少投递一次, 订阅方按 seq
+// 去重.
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"quant_api/config"
+	"quant_api/models"
+)
+
+const (
+	TypeLoopback = "loopback"
+	TypeRedis    = "redis"
+
+	DefaultChannel = "quant_api.config"
+)
+
+// Event 发布的配置变更, 删除时 NewValue 为空, 新建时 OldValue 为空
+type Event struct {
+	Seq      int64             `json:"seq"`
+	Action   string            `json:"action"`
+	Scope    string            `json:"scope"`
+	Name     string            `json:"name"`
+	OldValue models.JsonObject `json:"old_value"`
+	NewValue models.JsonObject `json:"new_value"`
+	Version  int               `json:"version"`
+	User     string            `json:"user"`
+	Time     string            `json:"time"`
+}
+
+func NewEvent(change *models.ConfigEvent) *Event {
+	return &Event{
+		Seq:      change.Seq,
+		Action:   change.Action,
+		Scope:    change.Scope,
+		Name:     change.Name,
+		OldValue: change.OldValue,
+		NewValue: change.Value,
+		Version:  change.Version,
+		User:     change.UpdateUser,
+		Time:     change.Time,
+	}
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, event *Event) error
+	Close() error
+}
+
+// New creates the publisher of cfg, returns nil when publishing is disabled
+func New(cfg config.Publisher) (Publisher, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case TypeLoopback:
+		return NewLoopback(), nil
+	case TypeRedis:
+		if cfg.Addr == "" {
+			return nil, fmt.Errorf("publisher: redis addr is empty")
+		}
+		channel := cfg.Channel
+		if channel == "" {
+			channel = DefaultChannel
+		}
+		redis := NewRedis(cfg.Addr, cfg.Password, channel)
+		if cfg.TimeoutSeconds > 0 {
+			redis.Timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+		}
+		return redis, nil
+	default:
+		return nil, fmt.Errorf("publisher: unknown type %q", cfg.Type)
+	}
+}
+
+// Sink 把 Publisher 接入 outbox
+type Sink struct {
+	publisher Publisher
+}
+
+func NewSink(publisher Publisher) *Sink {
+	return &Sink{publisher: publisher}
+}
+
+func (s *Sink) Name() string {
+	return "publisher"
+}
+
+func (s *Sink) Send(ctx context.Context, change *models.ConfigEvent) error {
+	return s.publisher.Publish(ctx, NewEvent(change))
+}
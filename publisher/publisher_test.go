@@ -0,0 +1,202 @@
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"quant_api/models"
+)
+
+// fakeRedis 只支持 AUTH 与 PUBLISH, 每个连接处理 perConn 条命令后关闭
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+	perConn  int
+
+	mu       sync.Mutex
+	conns    int
+	messages map[string][]string
+}
+
+func newFakeRedis(t *testing.T, password string, perConn int) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{ln: ln, password: password, perConn: perConn, messages: make(map[string][]string)}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns++
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authed := f.password == ""
+	for n := 0; f.perConn <= 0 || n < f.perConn; n++ {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			if args[1] != f.password {
+				io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authed = true
+			io.WriteString(conn, "+OK\r\n")
+		case "PUBLISH":
+			if !authed {
+				io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+				continue
+			}
+			f.mu.Lock()
+			f.messages[args[1]] = append(f.messages[args[1]], args[2])
+			f.mu.Unlock()
+			io.WriteString(conn, ":1\r\n")
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) published(channel string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.messages[channel]...)
+}
+
+func TestRedisPublish(t *testing.T) {
+	// 每个连接只处理 AUTH 与一次 PUBLISH, 第二次发布需要重连
+	server := newFakeRedis(t, "secret", 2)
+
+	redis := NewRedis(server.ln.Addr().String(), "secret", DefaultChannel)
+	defer redis.Close()
+
+	sink := NewSink(redis)
+	ctx := context.Background()
+	for seq := int64(1); seq <= 2; seq++ {
+		change := &models.ConfigEvent{
+			Seq:        seq,
+			Action:     models.RevisionActionUpdate,
+			Scope:      "stock",
+			Name:       "600000",
+			Value:      models.JsonObject{"up_limit": 11.0},
+			OldValue:   models.JsonObject{"up_limit": 10.0},
+			UpdateUser: "admin",
+		}
+		if err := sink.Send(ctx, change); err != nil {
+			t.Fatalf("Send(%d) error = %v", seq, err)
+		}
+	}
+
+	messages := server.published(DefaultChannel)
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(messages))
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(messages[1]), &event); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if event.Seq != 2 || event.Scope != "stock" || event.Name != "600000" || event.User != "admin" {
+		t.Errorf("event = %+v", event)
+	}
+	if event.OldValue["up_limit"] != 10.0 || event.NewValue["up_limit"] != 11.0 {
+		t.Errorf("event values = %v -> %v", event.OldValue, event.NewValue)
+	}
+}
+
+func TestRedisAuthError(t *testing.T) {
+	server := newFakeRedis(t, "secret", 0)
+
+	redis := NewRedis(server.ln.Addr().String(), "wrong", DefaultChannel)
+	redis.Timeout = time.Second
+	defer redis.Close()
+
+	if err := redis.Publish(context.Background(), &Event{Seq: 1}); err == nil {
+		t.Fatalf("Publish() error = nil, want auth error")
+	}
+	if len(server.published(DefaultChannel)) != 0 {
+		t.Errorf("message published without auth")
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	loopback := NewLoopback()
+	events, cancel := loopback.Subscribe(1)
+
+	ctx := context.Background()
+	if err := loopback.Publish(ctx, &Event{Seq: 1}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if event := <-events; event.Seq != 1 {
+		t.Errorf("got seq %d, want 1", event.Seq)
+	}
+
+	// 取消订阅后发布不再阻塞
+	cancel()
+	for seq := int64(2); seq <= 3; seq++ {
+		if err := loopback.Publish(ctx, &Event{Seq: seq}); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	loopback.Close()
+	if err := loopback.Publish(ctx, &Event{Seq: 4}); err != ErrClosed {
+		t.Errorf("Publish() after Close error = %v, want ErrClosed", err)
+	}
+}
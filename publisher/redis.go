@@ -0,0 +1,181 @@
+package publisher
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redis 通过 PUBLISH 命令把事件发布到 Redis 兼容服务的频道, 事件为 json.
+// 只实现了 RESP 协议中发布所需的部分, 连接出错时关闭, 下次发布时重连.
+type Redis struct {
+	Addr     string
+	Password string
+	Channel  string
+	// 单次发布的超时, 包括建立连接
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	closed bool
+}
+
+func NewRedis(addr, password, channel string) *Redis {
+	return &Redis{
+		Addr:     addr,
+		Password: password,
+		Channel:  channel,
+		Timeout:  5 * time.Second,
+	}
+}
+
+func (r *Redis) Publish(ctx context.Context, event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return ErrClosed
+	}
+
+	// 复用的连接可能已被服务端关闭, 此时重连后再发一次
+	reused := r.conn != nil
+	err = r.publish(ctx, payload)
+	if err != nil && reused && ctx.Err() == nil && isConnError(err) {
+		err = r.publish(ctx, payload)
+	}
+
+	return err
+}
+
+func (r *Redis) publish(ctx context.Context, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	if err := r.connect(ctx); err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	r.conn.SetDeadline(deadline)
+
+	if _, err := r.do("PUBLISH", r.Channel, string(payload)); err != nil {
+		var replyErr replyError
+		if !errors.As(err, &replyErr) {
+			r.closeConn()
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *Redis) connect(ctx context.Context) error {
+	if r.conn != nil {
+		return nil
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.reader = bufio.NewReader(conn)
+
+	if r.Password != "" {
+		deadline, _ := ctx.Deadline()
+		conn.SetDeadline(deadline)
+		if _, err := r.do("AUTH", r.Password); err != nil {
+			r.closeConn()
+			return fmt.Errorf("redis auth: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// do sends a command and reads a simple, error, integer or bulk string reply
+func (r *Redis) do(args ...string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(r.conn, b.String()); err != nil {
+		return "", err
+	}
+
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", replyError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return "", nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r.reader, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	default:
+		return "", fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func (r *Redis) closeConn() {
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+		r.reader = nil
+	}
+}
+
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	r.closeConn()
+	return nil
+}
+
+// replyError 服务端返回的错误, 连接仍然可用
+type replyError string
+
+func (e replyError) Error() string {
+	return "redis: " + string(e)
+}
+
+func isConnError(err error) bool {
+	var replyErr replyError
+	return !errors.As(err, &replyErr)
+}
@@ -46,3 +46,12 @@ func IntSliceToString(intSlice []int) string {
 	}
 	return result[:len(result)-1]
 }
+
+// Truncate 按字符截断到 n 个字符, 避免写入数据库时出现不完整的 utf8
+func Truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
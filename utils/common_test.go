@@ -40,3 +40,21 @@ func TestGetTimeDeltaSeconds(t *testing.T) {
 		})
 	}
 }
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"timeout", 10, "timeout"},
+		{"timeout", 4, "time"},
+		{"计算模块超时", 4, "计算模块"},
+		{"请求 timeout", 3, "请求 "},
+	}
+	for _, tt := range tests {
+		if got := Truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
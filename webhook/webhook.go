@@ -0,0 +1,275 @@
+// Package webhook 把配置变更与平仓结果投递到运维登记的回调地址. 事件先写入
+// webhook_deliveries, 再由后台协程签名发送, 失败时按指数退避重试.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"quant_api/models"
+	"quant_api/utils"
+)
+
+// 事件类型
+const (
+	EventConfigCreate = "config.create"
+	EventConfigUpdate = "config.update"
+	EventConfigDelete = "config.delete"
+	EventCloseOut     = "close_out"
+)
+
+// 请求头, 签名为 HMAC-SHA256(secret, timestamp + "." + body) 的十六进制
+const (
+	HeaderEvent     = "X-Quant-Event"
+	HeaderDelivery  = "X-Quant-Delivery"
+	HeaderTimestamp = "X-Quant-Timestamp"
+	HeaderSignature = "X-Quant-Signature"
+)
+
+const (
+	maxAttempts  = 8
+	baseBackoff  = 5 * time.Second
+	maxBackoff   = 30 * time.Minute
+	pollInterval = time.Second
+	pollBatch    = 20
+	// claimLease 认领的投递在该时长内不会被其他实例发送, 需大于一批投递的
+	// 最长耗时
+	claimLease = 5 * time.Minute
+)
+
+// Event 投递的 json 内容
+type Event struct {
+	ID    string      `json:"id"`
+	Type  string      `json:"type"`
+	Scope string      `json:"scope"`
+	Name  string      `json:"name"`
+	Time  int64       `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+func NewEvent(eventType, scope, name string, data interface{}) *Event {
+	return &Event{
+		ID:    utils.NewUUIDV4(),
+		Type:  eventType,
+		Scope: scope,
+		Name:  name,
+		Time:  time.Now().Unix(),
+		Data:  data,
+	}
+}
+
+// ConfigEventType maps a changelog action to the webhook event type
+func ConfigEventType(action string) string {
+	switch action {
+	case models.RevisionActionCreate:
+		return EventConfigCreate
+	case models.RevisionActionDelete:
+		return EventConfigDelete
+	default:
+		return EventConfigUpdate
+	}
+}
+
+// Sign returns the signature of body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Matches reports whether the webhook subscribes the event
+func Matches(w *models.Webhook, event *Event) bool {
+	if !w.Enabled {
+		return false
+	}
+	return contains(w.Events, event.Type) && contains(w.Scopes, event.Scope) && contains(w.Names, event.Name)
+}
+
+// contains 列表为空时匹配全部
+func contains(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+type Dispatcher struct {
+	logger *slog.Logger
+	client *http.Client
+}
+
+func NewDispatcher(logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		logger: logger.With("component", "webhook"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify queues the event for every matching webhook
+func (d *Dispatcher) Notify(event *Event) error {
+	webhooks, err := models.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range webhooks {
+		if !Matches(w, event) {
+			continue
+		}
+		if err := models.CreateWebhookDelivery(w.ID, event.ID, event.Type, payload); err != nil {
+			return fmt.Errorf("queue delivery for webhook %d: %w", w.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) Name() string {
+	return "webhook"
+}
+
+// Send queues a config change event, it makes the dispatcher an outbox sink.
+// The event id is derived from the sequence so that a redelivered change
+// keeps its id.
+func (d *Dispatcher) Send(ctx context.Context, change *models.ConfigEvent) error {
+	event := NewEvent(ConfigEventType(change.Action), change.Scope, change.Name, change)
+	event.ID = fmt.Sprintf("config-%d", change.Seq)
+	return d.Notify(event)
+}
+
+// Run sends the due deliveries until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.deliverDue(ctx)
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	deliveries, err := models.ClaimDueWebhookDeliveries(pollBatch, claimLease)
+	if err != nil {
+		d.logger.Error("load webhook deliveries failed", "error", err)
+		return
+	}
+
+	webhooks := make(map[int]*models.Webhook)
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		w, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			w, err = models.GetWebhook(delivery.WebhookID)
+			if err != nil && !errors.Is(err, models.ErrWebhookNotFound) {
+				d.logger.Error("load webhook failed", "webhook", delivery.WebhookID, "error", err)
+				continue
+			}
+			webhooks[delivery.WebhookID] = w
+		}
+
+		d.deliver(ctx, w, delivery)
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, w *models.Webhook, delivery *models.WebhookDelivery) {
+	delivery.Attempts++
+
+	if w == nil {
+		delivery.Status = models.DeliveryStatusFailed
+		delivery.LastError = "webhook deleted"
+	} else {
+		code, err := d.post(ctx, w, delivery)
+		delivery.ResponseCode = code
+		delivery.LastError = ""
+		switch {
+		case err == nil:
+			delivery.Status = models.DeliveryStatusSuccess
+		case delivery.Attempts >= maxAttempts:
+			delivery.Status = models.DeliveryStatusFailed
+			delivery.LastError = utils.Truncate(err.Error(), 500)
+		default:
+			delivery.NextAttempt = time.Now().Add(backoff(delivery.Attempts)).Unix()
+			delivery.LastError = utils.Truncate(err.Error(), 500)
+		}
+	}
+
+	d.logger.Info("webhook delivery", "delivery", delivery.ID, "webhook", delivery.WebhookID, "event", delivery.EventType,
+		"status", delivery.Status, "attempts", delivery.Attempts, "response_code", delivery.ResponseCode, "error", delivery.LastError)
+
+	if err := delivery.Save(); err != nil {
+		d.logger.Error("save webhook delivery failed", "delivery", delivery.ID, "error", err)
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, w *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.EventID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if w.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(w.Secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff 第 n 次失败后的等待时间, 5s 起按 2 倍增长
+func backoff(attempts int) time.Duration {
+	wait := baseBackoff << (attempts - 1)
+	if wait <= 0 || wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,50 @@
+package webhook
+
+import (
+	"testing"
+
+	"quant_api/models"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"close_out"}`)
+
+	got := Sign("secret", 1700000000, body)
+	if got != Sign("secret", 1700000000, body) {
+		t.Errorf("Sign() is not deterministic")
+	}
+	if got == Sign("other", 1700000000, body) {
+		t.Errorf("Sign() does not depend on secret")
+	}
+	if got == Sign("secret", 1700000001, body) {
+		t.Errorf("Sign() does not depend on timestamp")
+	}
+	if len(got) != len("sha256=")+64 {
+		t.Errorf("Sign() = %s, want sha256=<hex>", got)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	event := NewEvent(EventConfigUpdate, "stock", "600000", nil)
+
+	tests := []struct {
+		name    string
+		webhook models.Webhook
+		want    bool
+	}{
+		{"all", models.Webhook{Enabled: true}, true},
+		{"disabled", models.Webhook{Enabled: false}, false},
+		{"scope", models.Webhook{Enabled: true, Scopes: models.StringList{"stock"}}, true},
+		{"other scope", models.Webhook{Enabled: true, Scopes: models.StringList{"global"}}, false},
+		{"name", models.Webhook{Enabled: true, Names: models.StringList{"600001", "600000"}}, true},
+		{"other event", models.Webhook{Enabled: true, Events: models.StringList{EventCloseOut}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Matches(&tt.webhook, event); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}